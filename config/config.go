@@ -0,0 +1,244 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	StoreMongo  = "mongo"
+	StoreMemory = "memory"
+)
+
+// Config holds every setting the server needs. It is built once at startup by
+// Load and handed to the packages that need it.
+type Config struct {
+	Port            string
+	Store           string
+	MongoURL        string
+	Database        string
+	SecretKey       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	RequestTimeout  time.Duration
+
+	RevocationCleanupInterval time.Duration
+	RetentionPeriod           time.Duration
+	PurgeInterval             time.Duration
+	IdempotencyTTL            time.Duration
+
+	// RequireIfMatch makes updates and deletes of todos and users without an
+	// If-Match header fail instead of overwriting blindly.
+	RequireIfMatch bool
+}
+
+// setting describes one configuration value and every place it can come from.
+// Later sources override earlier ones: default, config file, environment, flag.
+type setting struct {
+	key   string
+	env   string
+	usage string
+	def   string
+	apply func(cfg *Config, value string) error
+}
+
+var settings = []setting{
+	{"port", "PORT", "port the HTTP server listens on", "8080", func(cfg *Config, v string) error {
+		cfg.Port = v
+		return nil
+	}},
+	{"store", "STORE", "storage backend: mongo, or memory to keep everything in process memory and lose it on exit", StoreMongo, func(cfg *Config, v string) error {
+		cfg.Store = strings.ToLower(v)
+		return nil
+	}},
+	{"mongo_url", "MONGO_DB_URL", "MongoDB connection string, of a replica set or sharded cluster since writes run in transactions", "", func(cfg *Config, v string) error {
+		cfg.MongoURL = v
+		return nil
+	}},
+	{"database", "DB_NAME", "MongoDB database name", "cluster0", func(cfg *Config, v string) error {
+		cfg.Database = v
+		return nil
+	}},
+	{"secret_key", "SECRET_KEY", "key used to sign JWTs", "", func(cfg *Config, v string) error {
+		cfg.SecretKey = v
+		return nil
+	}},
+	{"access_token_ttl", "ACCESS_TOKEN_TTL", "lifetime of access tokens", "24h", durationSetting(func(cfg *Config) *time.Duration { return &cfg.AccessTokenTTL })},
+	{"refresh_token_ttl", "REFRESH_TOKEN_TTL", "lifetime of refresh tokens", "168h", durationSetting(func(cfg *Config) *time.Duration { return &cfg.RefreshTokenTTL })},
+	{"request_timeout", "REQUEST_TIMEOUT", "timeout applied to each request's storage calls", "100s", durationSetting(func(cfg *Config) *time.Duration { return &cfg.RequestTimeout })},
+	{"revocation_cleanup_interval", "REVOCATION_CLEANUP_INTERVAL", "how often expired revoked tokens and idempotency records are dropped", "10m", durationSetting(func(cfg *Config) *time.Duration { return &cfg.RevocationCleanupInterval })},
+	{"retention_period", "RETENTION_PERIOD", "how long archived users and trashed todos are kept before being purged", "720h", durationSetting(func(cfg *Config) *time.Duration { return &cfg.RetentionPeriod })},
+	{"purge_interval", "PURGE_INTERVAL", "how often the purge of expired archives and trash runs", "1h", durationSetting(func(cfg *Config) *time.Duration { return &cfg.PurgeInterval })},
+	{"idempotency_ttl", "IDEMPOTENCY_TTL", "how long the response to a request with an Idempotency-Key is replayed for retries", "24h", durationSetting(func(cfg *Config) *time.Duration { return &cfg.IdempotencyTTL })},
+	{"require_if_match", "REQUIRE_IF_MATCH", "reject updates and deletes of todos and users sent without If-Match", "false", func(cfg *Config, v string) error {
+		require, err := strconv.ParseBool(v)
+		cfg.RequireIfMatch = require
+		return err
+	}},
+}
+
+func durationSetting(field func(cfg *Config) *time.Duration) func(cfg *Config, value string) error {
+	return func(cfg *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = d
+		return nil
+	}
+}
+
+// Load builds the configuration from the defaults, an optional YAML or TOML
+// file (-config or CONFIG_FILE), the environment (including a .env file in the
+// working directory) and the command line flags in args, then validates it.
+func Load(args []string) (*Config, error) {
+	godotenv.Load(".env")
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flagValues := map[string]*string{}
+	for _, s := range settings {
+		flagValues[s.key] = fs.String(strings.ReplaceAll(s.key, "_", "-"), "", s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, s := range settings {
+		values[s.key] = s.def
+	}
+
+	if *configFile != "" {
+		fileValues, err := readFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range fileValues {
+			if _, ok := values[key]; !ok {
+				return nil, fmt.Errorf("config file %s: unknown setting %q", *configFile, key)
+			}
+			values[key] = value
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			values[s.key] = v
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		key := strings.ReplaceAll(f.Name, "-", "_")
+		if v, ok := flagValues[key]; ok {
+			values[key] = *v
+		}
+	})
+
+	cfg := &Config{}
+	for _, s := range settings {
+		if err := s.apply(cfg, values[s.key]); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", s.key, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		values[key] = fmt.Sprint(value)
+	}
+	return values, nil
+}
+
+// Validate fills in derived defaults and reports the first invalid setting.
+func (cfg *Config) Validate() error {
+	port, err := strconv.Atoi(cfg.Port)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", cfg.Port)
+	}
+
+	// the memory store loses everything on restart, so it is never picked
+	// for a missing mongo_url
+	switch cfg.Store {
+	case StoreMongo:
+		if cfg.MongoURL == "" {
+			return errors.New("mongo_url is required when store is mongo; set store to memory to run without a database")
+		}
+		if cfg.Database == "" {
+			return errors.New("database is required when store is mongo")
+		}
+		if cfg.SecretKey == "" {
+			return errors.New("secret_key is required when store is mongo")
+		}
+	case StoreMemory:
+		// Nothing outlives the process, so a throwaway signing key is enough.
+		if cfg.SecretKey == "" {
+			key := make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return err
+			}
+			cfg.SecretKey = hex.EncodeToString(key)
+		}
+	default:
+		return fmt.Errorf("invalid store %q, must be %s or %s", cfg.Store, StoreMongo, StoreMemory)
+	}
+
+	if cfg.AccessTokenTTL <= 0 || cfg.RefreshTokenTTL <= 0 {
+		return errors.New("token lifetimes must be positive")
+	}
+	if cfg.RefreshTokenTTL < cfg.AccessTokenTTL {
+		return errors.New("refresh_token_ttl must not be shorter than access_token_ttl")
+	}
+	if cfg.RequestTimeout <= 0 {
+		return errors.New("request_timeout must be positive")
+	}
+	if cfg.RevocationCleanupInterval <= 0 {
+		return errors.New("revocation_cleanup_interval must be positive")
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		return errors.New("idempotency_ttl must be positive")
+	}
+	if cfg.RetentionPeriod <= 0 {
+		return errors.New("retention_period must be positive")
+	}
+	if cfg.PurgeInterval <= 0 {
+		return errors.New("purge_interval must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var storage database.StorageInspector
+
+// userTypes are the values User_type may take.
+var userTypes = []string{"ADMIN", "USER"}
+
+func PurgePreview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		report, err := helper.PlanPurge(ctx, helper.NextPurgeAt())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		models.RedactArchives(report.Archives)
+		c.JSON(http.StatusOK, gin.H{
+			"data":             report,
+			"retention_period": cfg.RetentionPeriod.String(),
+			"archive_count":    len(report.Archives),
+			"todo_count":       len(report.Todos),
+		})
+	}
+}
+
+// GetAdminStats sums up the whole system for ADMINs: users by type, signups
+// per day and users active over the last days days (30 by default), where a
+// user is active when their tokens were issued or refreshed, how many live
+// todos users have, the archived users and the size of each collection.
+func GetAdminStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		days := 30
+		if param := c.Query("days"); param != "" {
+			var err error
+			days, err = strconv.Atoi(param)
+			if err != nil || days < 1 || days > 365 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a number between 1 and 365"})
+				return
+			}
+		}
+
+		user, err := userStore.FindById(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching user"})
+			return
+		}
+		loc, err := helper.UserLocation(c, user)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		today := helper.StartOfDay(now, loc)
+		since := today.AddDate(0, 0, 1-days)
+
+		var users int64
+		byType := gin.H{}
+		for _, userType := range userTypes {
+			userType := userType
+			count, err := userStore.Count(ctx, database.UserFilter{User_type: &userType})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			byType[userType] = count
+			users += count
+		}
+
+		signups, err := userStore.CountCreatedByDay(ctx, since, loc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		activeSince := now.AddDate(0, 0, -days)
+		active, err := userStore.Count(ctx, database.UserFilter{Updated_after: &activeSince})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		todoCounts, err := todoStore.CountByUser(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		archived, err := archiveStore.Count(ctx, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cutoff := now.Add(-cfg.RetentionPeriod)
+		purgeable, err := archiveStore.Count(ctx, &cutoff)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		sizes, err := storage.CollectionSizes(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{
+			"users":          users,
+			"users_by_type":  byType,
+			"signups":        helper.DailyCounts(signups, since, today),
+			"active_users":   active,
+			"days":           days,
+			"timezone":       loc.String(),
+			"todos_per_user": helper.DistributeTodos(todoCounts, users),
+			"archived_users": gin.H{"total": archived, "past_retention": purgeable},
+			"collections":    sizes,
+		}})
+	}
+}
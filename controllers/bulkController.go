@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBulkOperations caps the operations of one bulk request.
+const maxBulkOperations = 100
+
+var transactions database.Transactor
+
+// findBulkTodo loads the todo an operation applies to, within the owner scope
+// of the request. Todos in the trash are only found when trashed is true. The
+// version stands in for If-Match, so it is required when If-Match is.
+func findBulkTodo(ctx context.Context, c *gin.Context, op models.BulkOperation, trashed bool) (models.Todo, error) {
+	var todo models.Todo
+	if op.Id == nil {
+		return todo, &requestError{http.StatusBadRequest, "id is required"}
+	}
+	if op.Version == nil && cfg.RequireIfMatch {
+		return todo, &requestError{http.StatusPreconditionRequired, "version is required"}
+	}
+	owner, err := helper.TodoOwner(c)
+	if err != nil {
+		return todo, &requestError{http.StatusBadRequest, err.Error()}
+	}
+
+	todo, err = todoStore.FindById(ctx, *op.Id)
+	if err == nil && ((owner != "" && todo.User_id != owner) || (!trashed && todo.Deleted_at != nil)) {
+		err = database.ErrNotFound
+	}
+	if err == database.ErrNotFound {
+		return todo, &requestError{http.StatusNotFound, "Todo not found"}
+	}
+	if err != nil {
+		return todo, &requestError{http.StatusInternalServerError, "Error accessing the database"}
+	}
+	if op.Version != nil && *op.Version != todo.Version {
+		return todo, &requestError{http.StatusPreconditionFailed, helper.ErrPreconditionFailed.Error()}
+	}
+	return todo, nil
+}
+
+// runBulkOperation applies one operation of a batch the way the single
+// request doing it would.
+func runBulkOperation(ctx context.Context, c *gin.Context, op models.BulkOperation) (result models.BulkResult, err error) {
+	result.Op = op.Op
+	result.Id = op.Id
+	if err := validate.Struct(op); err != nil {
+		return result, &requestError{http.StatusBadRequest, err.Error()}
+	}
+
+	if op.Op == models.BulkCreate {
+		if op.Todo == nil {
+			return result, &requestError{http.StatusBadRequest, "todo is required"}
+		}
+		todo, err := createTodo(ctx, c, *op.Todo)
+		if err != nil {
+			return result, err
+		}
+		result.Status = http.StatusCreated
+		result.Id = &todo.ID
+		result.Data = &todo
+		return result, nil
+	}
+
+	deleting := op.Op == models.BulkDelete
+	todo, err := findBulkTodo(ctx, c, op, deleting && op.Permanent)
+	if err != nil {
+		return result, err
+	}
+	before := todo
+
+	switch op.Op {
+	case models.BulkCheck, models.BulkUncheck:
+		check := op.Op == models.BulkCheck
+		result.Next = setCheck(&todo, check)
+		err = saveTodo(ctx, c, checkAction(check), before, &todo, result.Next)
+
+	case models.BulkEdit:
+		if len(op.Patch) == 0 {
+			return result, &requestError{http.StatusBadRequest, "patch is required"}
+		}
+		todo, result.Next, err = patchTodo(ctx, c, todo, helper.MergePatchType, op.Patch)
+
+	case models.BulkMove:
+		if err := verifyTodoList(ctx, todo.User_id, op.List_id); err != nil {
+			return result, err
+		}
+		todo.List_id = op.List_id
+		todo.Updated_at = time.Now()
+		err = saveTodo(ctx, c, models.RevisionEdit, before, &todo, nil)
+
+	case models.BulkDelete:
+		if op.Permanent {
+			if err := deleteTodoPermanently(ctx, c, todo); err != nil {
+				return result, err
+			}
+			result.Status = http.StatusOK
+			return result, nil
+		}
+		if todo.Deleted_at != nil {
+			return result, &requestError{http.StatusConflict, "Todo is already in the trash"}
+		}
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		todo.Deleted_at = &now
+		err = saveTodo(ctx, c, models.RevisionDelete, before, &todo, nil)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Status = http.StatusOK
+	result.Data = &todo
+	return result, nil
+}
+
+// BulkTodos applies a batch of create, check, uncheck, edit, delete and move
+// operations in order and reports the result of each. Without atomic, every
+// operation stands on its own. With atomic, they run in a transaction and the
+// first failure rolls back the ones before it and skips the rest.
+func BulkTodos() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var request models.BulkRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(request.Operations) > maxBulkOperations {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a bulk request takes at most " + strconv.Itoa(maxBulkOperations) + " operations"})
+			return
+		}
+
+		results := []models.BulkResult{}
+		run := func(ctx context.Context, i int, op models.BulkOperation) error {
+			result, err := runBulkOperation(ctx, c, op)
+			result.Index = i
+			if err != nil {
+				result.Status, result.Error = errorStatus(err, "Error applying the operation")
+				result.Data, result.Next = nil, nil
+			}
+			results = append(results, result)
+			return err
+		}
+
+		if !request.Atomic {
+			for i, op := range request.Operations {
+				run(ctx, i, op)
+			}
+			c.JSON(http.StatusOK, gin.H{"data": results})
+			return
+		}
+
+		failed := -1
+		err := transactions.Transaction(ctx, func(ctx context.Context) error {
+			// a transaction may be retried from the start
+			results = []models.BulkResult{}
+			failed = -1
+			for i, op := range request.Operations {
+				if err := run(ctx, i, op); err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{"data": results})
+			return
+		}
+		if failed < 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying the operations"})
+			return
+		}
+
+		reason := "operation " + strconv.Itoa(failed) + " failed"
+		for i := range results[:failed] {
+			op := request.Operations[i]
+			results[i] = models.BulkResult{Index: i, Op: op.Op, Id: op.Id, Status: http.StatusFailedDependency, Error: "rolled back, " + reason}
+		}
+		for i := failed + 1; i < len(request.Operations); i++ {
+			op := request.Operations[i]
+			results = append(results, models.BulkResult{Index: i, Op: op.Op, Id: op.Id, Status: http.StatusFailedDependency, Error: "not run, " + reason})
+		}
+		c.JSON(results[failed].Status, gin.H{"error": reason + ", nothing was applied", "data": results})
+	}
+}
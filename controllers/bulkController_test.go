@@ -0,0 +1,171 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"nitiwat/database"
+	"nitiwat/models"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createTodo adds a todo through the API and returns its id.
+func createTodo(t *testing.T, router *gin.Engine, token string, body string) primitive.ObjectID {
+	response := serve(router, http.MethodPost, "/todos", token, "application/json", body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("POST /todos answered %d: %s", response.Code, response.Body)
+	}
+	var created struct {
+		Data struct {
+			InsertedID primitive.ObjectID
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decoding the todo: %v", err)
+	}
+	return created.Data.InsertedID
+}
+
+// storedState is everything a batch of a user can change: their todos, trash
+// included, and the history of each.
+type storedState struct {
+	Todos     []models.Todo
+	Revisions map[primitive.ObjectID][]models.Revision
+}
+
+func readState(t *testing.T, stores database.Stores, owner string) storedState {
+	ctx := context.Background()
+	todos, err := stores.Todos.Find(ctx, database.TodoFilter{User_id: owner, IncludeTrashed: true})
+	if err != nil {
+		t.Fatalf("finding the todos: %v", err)
+	}
+	state := storedState{Todos: todos, Revisions: map[primitive.ObjectID][]models.Revision{}}
+	for _, todo := range todos {
+		if state.Revisions[todo.ID], err = stores.Revisions.Find(ctx, todo.ID); err != nil {
+			t.Fatalf("finding the revisions: %v", err)
+		}
+	}
+	return state
+}
+
+func TestAtomicBulkRollsBack(t *testing.T) {
+	// every batch ends with an operation that fails with the given status,
+	// after operations that touch every store a batch writes to
+	cases := []struct {
+		name   string
+		last   string
+		status int
+	}{
+		{"missing todo", `{"op": "check", "id": "` + primitive.NewObjectID().Hex() + `"}`, http.StatusNotFound},
+		{"stale version", `{"op": "uncheck", "id": "{plain}", "version": 99}`, http.StatusPreconditionFailed},
+		{"invalid patch", `{"op": "edit", "id": "{plain}", "patch": {"title": ""}}`, http.StatusUnprocessableEntity},
+		{"duplicate title", `{"op": "create", "todo": {"title": "Water plants", "description": "Again"}}`, http.StatusConflict},
+		{"unknown list", `{"op": "move", "id": "{plain}", "list_id": "` + primitive.NewObjectID().Hex() + `"}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			router, stores := newServer(t)
+			user := signupUser(t, router, "ann@example.com", "0800000001")
+			recurring := createTodo(t, router, user.token, `{"title": "Water plants", "description": "Every day", "due_at": "2026-01-05T09:00:00Z", "recurrence": {"frequency": "daily"}}`)
+			plain := createTodo(t, router, user.token, `{"title": "Buy milk", "description": "Two litres"}`)
+			doomed := createTodo(t, router, user.token, `{"title": "Old note", "description": "Stale"}`)
+			before := readState(t, stores, user.uid)
+
+			operations := []string{
+				`{"op": "create", "todo": {"title": "Call mum", "description": "Sunday"}}`,
+				`{"op": "check", "id": "` + recurring.Hex() + `"}`,
+				`{"op": "edit", "id": "` + plain.Hex() + `", "patch": {"title": "Buy oat milk"}}`,
+				`{"op": "delete", "id": "` + doomed.Hex() + `", "permanent": true}`,
+				strings.Replace(c.last, "{plain}", plain.Hex(), 1),
+			}
+			body := `{"atomic": true, "operations": [` + strings.Join(operations, ", ") + `]}`
+			response := serve(router, http.MethodPost, "/todos/bulk", user.token, "application/json", body)
+			if response.Code != c.status {
+				t.Fatalf("the batch answered %d, want %d: %s", response.Code, c.status, response.Body)
+			}
+
+			var answer struct {
+				Data []models.BulkResult `json:"data"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &answer); err != nil {
+				t.Fatalf("decoding the results: %v", err)
+			}
+			if len(answer.Data) != len(operations) {
+				t.Fatalf("the batch reported %d results for %d operations", len(answer.Data), len(operations))
+			}
+			for i, result := range answer.Data[:len(operations)-1] {
+				if result.Index != i || result.Status != http.StatusFailedDependency || result.Data != nil || result.Next != nil {
+					t.Errorf("operation %d was reported as %+v, want rolled back", i, result)
+				}
+			}
+			if last := answer.Data[len(operations)-1]; last.Status != c.status || last.Error == "" {
+				t.Errorf("the failed operation was reported as %+v", last)
+			}
+
+			if after := readState(t, stores, user.uid); !reflect.DeepEqual(after, before) {
+				t.Errorf("the failed batch changed the todos:\nbefore %+v\nafter  %+v", before, after)
+			}
+		})
+	}
+}
+
+func TestBulkAppliesWhatSucceeds(t *testing.T) {
+	router, stores := newServer(t)
+	user := signupUser(t, router, "ann@example.com", "0800000001")
+	plain := createTodo(t, router, user.token, `{"title": "Buy milk", "description": "Two litres"}`).Hex()
+	missing := primitive.NewObjectID().Hex()
+
+	// the batches run in order on the same todo
+	batches := []struct {
+		name        string
+		body        string
+		want        []int
+		check       bool
+		description string
+	}{
+		{
+			"without atomic a failure stands alone",
+			`{"operations": [{"op": "edit", "id": "` + plain + `", "patch": {"description": "Oat"}}, {"op": "check", "id": "` + missing + `"}, {"op": "check", "id": "` + plain + `"}]}`,
+			[]int{http.StatusOK, http.StatusNotFound, http.StatusOK},
+			true, "Oat",
+		},
+		{
+			"atomic without a failure applies entirely",
+			`{"atomic": true, "operations": [{"op": "edit", "id": "` + plain + `", "patch": {"description": "Whole"}}, {"op": "uncheck", "id": "` + plain + `"}]}`,
+			[]int{http.StatusOK, http.StatusOK},
+			false, "Whole",
+		},
+	}
+	for _, b := range batches {
+		response := serve(router, http.MethodPost, "/todos/bulk", user.token, "application/json", b.body)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: the batch answered %d: %s", b.name, response.Code, response.Body)
+		}
+		var answer struct {
+			Data []models.BulkResult `json:"data"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &answer); err != nil {
+			t.Fatalf("%s: decoding the results: %v", b.name, err)
+		}
+		statuses := []int{}
+		for _, result := range answer.Data {
+			statuses = append(statuses, result.Status)
+		}
+		if !reflect.DeepEqual(statuses, b.want) {
+			t.Errorf("%s: the operations answered %v, want %v", b.name, statuses, b.want)
+		}
+
+		todo, err := stores.Todos.FindById(context.Background(), *answer.Data[0].Id)
+		if err != nil {
+			t.Fatalf("%s: finding the todo: %v", b.name, err)
+		}
+		if todo.Check != b.check || todo.Description != b.description {
+			t.Errorf("%s: the batch left the todo at %+v", b.name, todo)
+		}
+	}
+}
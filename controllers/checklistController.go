@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// syncAutoCheck checks a todo with Auto_check set once all of its items are
+// checked, and unchecks it when one is not, returning the next occurrence
+// setCheck may produce.
+func syncAutoCheck(todo *models.Todo) *models.Todo {
+	if !todo.Auto_check || len(todo.Items) == 0 {
+		return nil
+	}
+	done := todo.Progress() == 100
+	if todo.Check == done {
+		return nil
+	}
+	return setCheck(todo, done)
+}
+
+// copyItems copies the items of a todo so they can be changed without
+// touching the version the change is recorded against.
+func copyItems(items []models.ChecklistItem) []models.ChecklistItem {
+	if items == nil {
+		return nil
+	}
+	return append([]models.ChecklistItem{}, items...)
+}
+
+// findChecklistItem returns the index of the item named by the item_id
+// parameter. When ok is false the response has already been written.
+func findChecklistItem(c *gin.Context, todo models.Todo) (index int, ok bool) {
+	itemID, err := primitive.ObjectIDFromHex(c.Param("item_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID format"})
+		return 0, false
+	}
+	for i, item := range todo.Items {
+		if item.ID == itemID {
+			return i, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Checklist item not found"})
+	return 0, false
+}
+
+// saveChecklist renumbers the items of a todo, applies Auto_check and stores
+// the todo, which was before as loaded.
+func saveChecklist(ctx context.Context, c *gin.Context, before models.Todo, todo models.Todo) {
+	for i := range todo.Items {
+		todo.Items[i].Position = i
+	}
+	next := syncAutoCheck(&todo)
+	todo.Updated_at = time.Now()
+
+	if err := saveTodo(ctx, c, models.RevisionEdit, before, &todo, next); err != nil {
+		updateFailed(c, err, "Error updating the todo")
+		return
+	}
+	c.Header("ETag", helper.ETag(todo.Version))
+	c.JSON(http.StatusOK, gin.H{"data": todo})
+}
+
+func AddChecklistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var item models.ChecklistItem
+		if err := c.BindJSON(&item); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		item.Title = strings.TrimSpace(item.Title)
+		if err := validate.Struct(item); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
+		before := todo
+		todo.Items = copyItems(todo.Items)
+
+		item.ID = primitive.NewObjectID()
+		item.Check = false
+		todo.Items = append(todo.Items, item)
+		saveChecklist(ctx, c, before, todo)
+	}
+}
+
+// UpdateChecklistItem checks or unchecks one item.
+func UpdateChecklistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var update models.UpdateTodo
+		if err := c.BindJSON(&update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
+		before := todo
+		todo.Items = copyItems(todo.Items)
+		i, ok := findChecklistItem(c, todo)
+		if !ok {
+			return
+		}
+
+		todo.Items[i].Check = update.Check
+		saveChecklist(ctx, c, before, todo)
+	}
+}
+
+// ReorderChecklistItems sets the order of a todo's items. The body must name
+// each of them exactly once.
+func ReorderChecklistItems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var request models.ReorderItemsRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
+		before := todo
+		todo.Items = copyItems(todo.Items)
+
+		remaining := map[primitive.ObjectID]models.ChecklistItem{}
+		for _, item := range todo.Items {
+			remaining[item.ID] = item
+		}
+		items := []models.ChecklistItem{}
+		for _, id := range request.Ids {
+			item, found := remaining[id]
+			if !found {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ids must name each checklist item exactly once, got " + id.Hex()})
+				return
+			}
+			delete(remaining, id)
+			items = append(items, item)
+		}
+		if len(remaining) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ids must name each checklist item exactly once"})
+			return
+		}
+
+		todo.Items = items
+		saveChecklist(ctx, c, before, todo)
+	}
+}
+
+func DeleteChecklistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
+		before := todo
+		todo.Items = copyItems(todo.Items)
+		i, ok := findChecklistItem(c, todo)
+		if !ok {
+			return
+		}
+
+		todo.Items = append(todo.Items[:i], todo.Items[i+1:]...)
+		saveChecklist(ctx, c, before, todo)
+	}
+}
@@ -2,39 +2,66 @@ package controllers
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
 	"nitiwat/models"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func GetAllDeleted() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
 
-		cursor, err := deleteCollections.Find(ctx, bson.M{})
+		limit, err := helper.PageLimit(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var deletedData []models.DeleteModal
+		var after *primitive.ObjectID
+		if param := c.Query("after"); param != "" {
+			if after, err = database.DecodeIdCursor(param, "deleted"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "after is not a deleted cursor"})
+				return
+			}
+		}
 
-		if err = cursor.All(ctx, &deletedData); err != nil {
+		deletedData, err := archiveStore.Find(ctx, after, limit+1)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"data": deletedData})
+
+		var next *string
+		if int64(len(deletedData)) > limit {
+			deletedData = deletedData[:limit]
+			cursor := database.EncodeIdCursor("deleted", deletedData[limit-1].ID)
+			next = &cursor
+		}
+		models.RedactArchives(deletedData)
+		c.JSON(http.StatusOK, gin.H{"data": deletedData, "next_cursor": next})
 
 	}
 }
 
 func GetDeletedById() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
 
 		del_idParam := c.Param("del_id")
@@ -44,15 +71,132 @@ func GetDeletedById() gin.HandlerFunc {
 			return
 		}
 
-		var delData models.DeleteModal
+		delData, err := archiveStore.FindById(ctx, delId)
+		if err != nil {
+			if err == database.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Deleted data not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": delData.Redacted()})
 
-		err = deleteCollections.FindOne(ctx, bson.M{"id": delId}).Decode(&delData)
+	}
+}
+
+func RestoreDeleted() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		delId, err := primitive.ObjectIDFromHex(c.Param("del_id"))
 		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+			return
+		}
+
+		var request models.RestoreRequest
+		if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		delData, err := archiveStore.FindById(ctx, delId)
+		if err != nil {
+			if err == database.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Deleted data not found"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"data": delId})
+		user := delData.User
+		if request.Email != nil {
+			user.Email = request.Email
+		}
+		if request.Phone != nil {
+			user.Phone = request.Phone
+		}
+
+		// users created since the archive may have taken the email or phone
+		conflicts := []string{}
+		if _, err := userStore.FindById(ctx, user.User_id); err == nil {
+			conflicts = append(conflicts, "user_id")
+		}
+		if countEmail, err := userStore.Count(ctx, database.UserFilter{Email: user.Email}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for the email"})
+			return
+		} else if countEmail > 0 {
+			conflicts = append(conflicts, "email")
+		}
+		if countPhone, err := userStore.Count(ctx, database.UserFilter{Phone: user.Phone}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for the phone number"})
+			return
+		} else if countPhone > 0 {
+			conflicts = append(conflicts, "phone")
+		}
+		if len(conflicts) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     "Another user already uses these details, pass replacements in the request body",
+				"conflicts": conflicts,
+			})
+			return
+		}
+
+		// old sessions must not come back to life with the user
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		restoredBy := c.GetString("uid")
+		user.Token = nil
+		user.Refresh_token = nil
+		user.Sessions = nil
+		user.Restored_by = &restoredBy
+		user.Restored_at = &now
+		user.Updated_at = now
+
+		// a second restore of the same archive finds it gone and undoes its
+		// inserts, so the user and their todos never come back twice
+		err = transactions.Transaction(ctx, func(ctx context.Context) error {
+			if err := userStore.Insert(ctx, user); err != nil {
+				return err
+			}
+			for _, todo := range delData.Todos {
+				if err := todoStore.Insert(ctx, todo); err != nil {
+					return err
+				}
+			}
+			for _, tag := range delData.Tags {
+				if err := tagStore.Insert(ctx, tag); err != nil {
+					return err
+				}
+			}
+			for _, list := range delData.Lists {
+				if err := listStore.Insert(ctx, list); err != nil {
+					return err
+				}
+			}
+			return archiveStore.Delete(ctx, delId)
+		})
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted data not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while restoring user"})
+			return
+		}
 
+		c.JSON(http.StatusOK, gin.H{"data": user.Redacted(), "restored_todos": len(delData.Todos)})
 	}
 }
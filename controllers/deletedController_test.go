@@ -0,0 +1,118 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"nitiwat/database"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// deleteUser has an ADMIN delete the user and returns the id of the archive
+// holding them.
+func deleteUser(t *testing.T, router *gin.Engine, stores database.Stores, token string, userId string) string {
+	response := serve(router, http.MethodDelete, "/users/"+userId, token, "", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("DELETE /users/%s answered %d: %s", userId, response.Code, response.Body)
+	}
+	archives, err := stores.Archive.Find(context.Background(), nil, 0)
+	if err != nil {
+		t.Fatalf("finding the archives: %v", err)
+	}
+	for _, archive := range archives {
+		if archive.User.User_id == userId {
+			return archive.ID.Hex()
+		}
+	}
+	t.Fatalf("no archive holds user %s", userId)
+	return ""
+}
+
+func TestRestoreDeletedRecordsTheRestorer(t *testing.T) {
+	router, stores := newServer(t)
+	user := signupUser(t, router, "ann@example.com", "0800000001")
+	response := serve(router, http.MethodPost, "/todos", user.token, "application/json", `{"title": "Buy milk", "description": "Two litres"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("POST /todos answered %d: %s", response.Code, response.Body)
+	}
+	admin := primitive.NewObjectID().Hex()
+	token := accessToken(t, admin, "ADMIN")
+	archiveId := deleteUser(t, router, stores, token, user.uid)
+
+	response = serve(router, http.MethodPost, "/deleted/"+archiveId+"/restore", token, "", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("restoring answered %d: %s", response.Code, response.Body)
+	}
+	restored, err := stores.Users.FindById(context.Background(), user.uid)
+	if err != nil {
+		t.Fatalf("finding the restored user: %v", err)
+	}
+	if restored.Restored_by == nil || *restored.Restored_by != admin || restored.Restored_at == nil {
+		t.Errorf("the restore was recorded as by %v at %v, want by %s", restored.Restored_by, restored.Restored_at, admin)
+	}
+
+	// a second restore of the same archive must not bring the todos back twice
+	response = serve(router, http.MethodPost, "/deleted/"+archiveId+"/restore", token, "", "")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("restoring again answered %d, want 404: %s", response.Code, response.Body)
+	}
+	count, err := stores.Todos.Count(context.Background(), database.TodoFilter{User_id: user.uid})
+	if err != nil {
+		t.Fatalf("counting the todos: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("the user has %d todos after the restores, want 1", count)
+	}
+}
+
+func TestRestoreDeletedRefusesTakenDetails(t *testing.T) {
+	router, stores := newServer(t)
+	user := signupUser(t, router, "ann@example.com", "0800000001")
+	token := accessToken(t, primitive.NewObjectID().Hex(), "ADMIN")
+	archiveId := deleteUser(t, router, stores, token, user.uid)
+	signupUser(t, router, "ann@example.com", "0800000001")
+
+	cases := []struct {
+		name      string
+		body      string
+		conflicts []string
+	}{
+		{"both taken", "", []string{"email", "phone"}},
+		{"phone taken", `{"email": "ann.lee@example.com"}`, []string{"phone"}},
+		{"email taken", `{"phone": "0800000002"}`, []string{"email"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response := serve(router, http.MethodPost, "/deleted/"+archiveId+"/restore", token, "application/json", c.body)
+			if response.Code != http.StatusConflict {
+				t.Fatalf("restoring answered %d, want 409: %s", response.Code, response.Body)
+			}
+			var refusal struct {
+				Conflicts []string `json:"conflicts"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &refusal); err != nil {
+				t.Fatalf("decoding the refusal: %v", err)
+			}
+			if !reflect.DeepEqual(refusal.Conflicts, c.conflicts) {
+				t.Errorf("the conflicts are %v, want %v", refusal.Conflicts, c.conflicts)
+			}
+		})
+	}
+
+	body := `{"email": "ann.lee@example.com", "phone": "0800000002"}`
+	response := serve(router, http.MethodPost, "/deleted/"+archiveId+"/restore", token, "application/json", body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("restoring with new details answered %d: %s", response.Code, response.Body)
+	}
+	restored, err := stores.Users.FindById(context.Background(), user.uid)
+	if err != nil {
+		t.Fatalf("finding the restored user: %v", err)
+	}
+	if *restored.Email != "ann.lee@example.com" || *restored.Phone != "0800000002" {
+		t.Errorf("the user was restored with %s and %s", *restored.Email, *restored.Phone)
+	}
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// todoExporter writes todos out in one export format.
+type todoExporter interface {
+	begin() error
+	write(todo models.Todo) error
+	end() error
+}
+
+// csvExporter writes a header row, then a row per todo.
+type csvExporter struct {
+	w io.Writer
+}
+
+func (e *csvExporter) begin() error {
+	return helper.WriteCSVRecord(e.w, helper.TodoCSVHeader)
+}
+
+func (e *csvExporter) write(todo models.Todo) error {
+	record, err := helper.TodoCSVRecord(todo)
+	if err != nil {
+		return err
+	}
+	return helper.WriteCSVRecord(e.w, record)
+}
+
+func (e *csvExporter) end() error {
+	return nil
+}
+
+// jsonExporter writes a JSON array of todos, or one todo per line when lines
+// is set.
+type jsonExporter struct {
+	w       io.Writer
+	lines   bool
+	written bool
+}
+
+func (e *jsonExporter) begin() error {
+	if e.lines {
+		return nil
+	}
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonExporter) write(todo models.Todo) error {
+	if !e.lines && e.written {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.written = true
+	return json.NewEncoder(e.w).Encode(todo)
+}
+
+func (e *jsonExporter) end() error {
+	if e.lines {
+		return nil
+	}
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// ExportTodos streams the caller's todos, or those of the user an ADMIN names
+// with as_user, oldest first as a csv, json or ndjson download. Todos in the
+// trash and in archived lists are left out unless trashed or archived is
+// true. The todos are written as they are read, so a failure halfway ends the
+// download early rather than changing its status, and a large export is not
+// cut off by the request timeout.
+func ExportTodos() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		format := c.DefaultQuery("format", helper.ExportJSON)
+		contentType, ok := helper.ExportContentTypes[format]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, json or ndjson"})
+			return
+		}
+		flags := map[string]bool{}
+		for _, name := range []string{"trashed", "archived"} {
+			if param := c.Query(name); param != "" {
+				value, err := strconv.ParseBool(param)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be true or false"})
+					return
+				}
+				flags[name] = value
+			}
+		}
+
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		archivedLists := map[primitive.ObjectID]bool{}
+		if !flags["archived"] {
+			lists, err := listStore.Find(ctx, owner, true)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			for _, list := range lists {
+				archivedLists[list.ID] = true
+			}
+		}
+
+		var exporter todoExporter
+		switch format {
+		case helper.ExportCSV:
+			exporter = &csvExporter{w: c.Writer}
+		default:
+			exporter = &jsonExporter{w: c.Writer, lines: format == helper.ExportNDJSON}
+		}
+
+		// the response starts with the first todo, so an error before it can
+		// still be answered as one
+		started := false
+		start := func() error {
+			started = true
+			c.Header("Content-Type", contentType)
+			c.Header("Content-Disposition", `attachment; filename="`+helper.ExportFilename(format, time.Now())+`"`)
+			c.Status(http.StatusOK)
+			return exporter.begin()
+		}
+
+		filter := database.TodoFilter{
+			User_id:        owner,
+			IncludeTrashed: flags["trashed"],
+			Sort:           []database.SortField{{Field: "created_at"}},
+		}
+		// streaming lasts as long as the client keeps reading, so it is bound
+		// to the request rather than to the request timeout
+		err = todoStore.Each(c.Request.Context(), filter, func(todo models.Todo) error {
+			if todo.List_id != nil && archivedLists[*todo.List_id] {
+				return nil
+			}
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			return exporter.write(todo)
+		})
+		if err == nil && !started {
+			err = start()
+		}
+		if err == nil {
+			err = exporter.end()
+		}
+		if err != nil && !started {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error exporting the todos of %q: %v", owner, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var revisionStore database.RevisionStore
+
+// unaudited lists the todo fields that change with every revision or are
+// derived from others, and so are left out of the diff.
+var unaudited = map[string]bool{
+	"updated_at": true,
+	"progress":   true,
+	"version":    true,
+}
+
+// todoFields returns the JSON fields of a todo, or none for a nil todo.
+func todoFields(todo *models.Todo) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if todo == nil {
+		return fields
+	}
+	data, _ := json.Marshal(todo)
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// diffTodos lists the fields that differ between two versions of a todo.
+func diffTodos(before *models.Todo, after *models.Todo) []models.FieldChange {
+	old, current := todoFields(before), todoFields(after)
+
+	names := []string{}
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range current {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []models.FieldChange{}
+	for _, name := range names {
+		if unaudited[name] || reflect.DeepEqual(old[name], current[name]) {
+			continue
+		}
+		changes = append(changes, models.FieldChange{Field: name, Before: old[name], After: current[name]})
+	}
+	return changes
+}
+
+// recordRevision adds a revision for a change the request made to a todo.
+// before is nil for a created todo. Callers record it in the transaction that
+// saves the change, so that a change is never kept without its revision.
+func recordRevision(ctx context.Context, c *gin.Context, action string, before *models.Todo, after models.Todo) error {
+	revision := models.Revision{
+		ID:         primitive.NewObjectID(),
+		Todo_id:    after.ID,
+		User_id:    after.User_id,
+		Actor:      c.GetString("uid"),
+		Action:     action,
+		Changes:    diffTodos(before, &after),
+		Snapshot:   after,
+		Created_at: time.Now(),
+	}
+	return revisionStore.Insert(ctx, revision)
+}
+
+// checkAction names the revision of a change of Check.
+func checkAction(check bool) string {
+	if check {
+		return models.RevisionCheck
+	}
+	return models.RevisionUncheck
+}
+
+func GetTodoHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		todo, ok := findOwnedTodo(ctx, c)
+		if !ok {
+			return
+		}
+
+		revisions, err := revisionStore.Find(ctx, todo.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": revisions})
+	}
+}
+
+// RevertTodo brings the content of a todo back to what it was after the given
+// revision. Its identity, owner, place in the trash, position, recurrence and
+// series are kept, and the revert is itself recorded as a revision. Checking
+// a recurring todo this way hands its recurrence on to the next occurrence,
+// as checking it does anywhere else.
+func RevertTodo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
+
+		revisionID, err := primitive.ObjectIDFromHex(c.Param("revision_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision ID format"})
+			return
+		}
+		revision, err := revisionStore.FindById(ctx, revisionID)
+		if err == nil && revision.Todo_id != todo.ID {
+			err = database.ErrNotFound
+		}
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error accessing the database"})
+			return
+		}
+
+		snapshot := revision.Snapshot
+		if !checkTodoTags(ctx, c, todo.User_id, snapshot.Tags) {
+			return
+		}
+		if !checkTodoList(ctx, c, todo.User_id, snapshot.List_id) {
+			return
+		}
+		if snapshot.Title != todo.Title {
+			if _, err := todoStore.FindByTitle(ctx, todo.User_id, snapshot.Title); err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "title is exist on database"})
+				return
+			}
+		}
+
+		before := todo
+		todo.Title = snapshot.Title
+		todo.Description = snapshot.Description
+		todo.Priority = snapshot.Priority
+		todo.Due_at = snapshot.Due_at
+		todo.Timezone = snapshot.Timezone
+		todo.Tags = snapshot.Tags
+		todo.List_id = snapshot.List_id
+		todo.Items = snapshot.Items
+		todo.Auto_check = snapshot.Auto_check
+
+		// Check goes through setCheck like any other change of it, unless
+		// Auto_check derives it from the items
+		var next *models.Todo
+		if todo.Auto_check && len(todo.Items) > 0 {
+			next = syncAutoCheck(&todo)
+		} else if snapshot.Check != todo.Check {
+			next = setCheck(&todo, snapshot.Check)
+		}
+		todo.Updated_at = time.Now()
+
+		if err := saveTodo(ctx, c, models.RevisionRevert, before, &todo, next); err != nil {
+			updateFailed(c, err, "Error reverting the todo")
+			return
+		}
+		c.Header("ETag", helper.ETag(todo.Version))
+
+		if next != nil {
+			c.JSON(http.StatusOK, gin.H{"data": todo, "next": next})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": todo})
+	}
+}
@@ -0,0 +1,378 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var listStore database.ListStore
+
+// findOwnedList loads the list with the given id, reporting lists outside the
+// owner scope of the request as not found. When ok is false the response has
+// already been written.
+func findOwnedList(ctx context.Context, c *gin.Context, id string) (list models.List, ok bool) {
+	owner, err := helper.TodoOwner(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return list, false
+	}
+
+	listID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid list ID format"})
+		return list, false
+	}
+
+	list, err = listStore.FindById(ctx, listID)
+	if err == nil && owner != "" && list.User_id != owner {
+		err = database.ErrNotFound
+	}
+	if err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+			return list, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error accessing the database"})
+		return list, false
+	}
+	return list, true
+}
+
+// verifyTodoList makes sure a todo of the user can be put in the list: the
+// list must be the user's and not archived. No list is always fine.
+func verifyTodoList(ctx context.Context, userId string, listId *primitive.ObjectID) error {
+	if listId == nil {
+		return nil
+	}
+	list, err := listStore.FindById(ctx, *listId)
+	if err == nil && list.User_id != userId {
+		err = database.ErrNotFound
+	}
+	if err == database.ErrNotFound {
+		return &requestError{http.StatusBadRequest, "unknown list " + listId.Hex()}
+	}
+	if err != nil {
+		return &requestError{http.StatusInternalServerError, "Error accessing the database"}
+	}
+	if list.Archived_at != nil {
+		return &requestError{http.StatusConflict, "list " + list.Name + " is archived"}
+	}
+	return nil
+}
+
+// checkTodoList is verifyTodoList for handlers. When ok is false the response
+// has already been written.
+func checkTodoList(ctx context.Context, c *gin.Context, userId string, listId *primitive.ObjectID) (ok bool) {
+	if err := verifyTodoList(ctx, userId, listId); err != nil {
+		requestFailed(c, err, "Error accessing the database")
+		return false
+	}
+	return true
+}
+
+// countListTodos fills in the open and done counts of the lists.
+func countListTodos(ctx context.Context, owner string, lists []models.List) error {
+	counts, err := todoStore.CountByList(ctx, owner)
+	if err != nil {
+		return err
+	}
+	for i := range lists {
+		lists[i].Open_count = counts[lists[i].ID].Open
+		lists[i].Done_count = counts[lists[i].ID].Done
+	}
+	return nil
+}
+
+func GetLists() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		archived := false
+		if param := c.Query("archived"); param != "" {
+			if archived, err = strconv.ParseBool(param); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "archived must be true or false"})
+				return
+			}
+		}
+
+		lists, err := listStore.Find(ctx, owner, archived)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := countListTodos(ctx, owner, lists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": lists})
+	}
+}
+
+func GetListById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		list, ok := findOwnedList(ctx, c, c.Param("list_id"))
+		if !ok {
+			return
+		}
+
+		lists := []models.List{list}
+		if err := countListTodos(ctx, list.User_id, lists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": lists[0]})
+	}
+}
+
+func AddList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var list models.List
+		if err := c.BindJSON(&list); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		list.Name = strings.TrimSpace(list.Name)
+		if err := validate.Struct(list); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if owner == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "as_user must name a single user when creating a list"})
+			return
+		}
+
+		// new lists go last
+		lists, err := listStore.Find(ctx, owner, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		list.ID = primitive.NewObjectID()
+		list.User_id = owner
+		list.Position = 0
+		if len(lists) > 0 {
+			list.Position = lists[len(lists)-1].Position + 1
+		}
+		list.Archived_at = nil
+		list.Created_at = time.Now()
+		list.Updated_at = list.Created_at
+		if err := listStore.Insert(ctx, list); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "List not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"InsertedID": list.ID}})
+	}
+}
+
+func RenameList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var update models.List
+		if err := c.BindJSON(&update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		update.Name = strings.TrimSpace(update.Name)
+		if err := validate.Var(update.Name, "required,max=100"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required and at most 100 characters"})
+			return
+		}
+
+		list, ok := findOwnedList(ctx, c, c.Param("list_id"))
+		if !ok {
+			return
+		}
+
+		list.Name = update.Name
+		list.Updated_at = time.Now()
+		if err := listStore.Update(ctx, list); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating list"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": list})
+	}
+}
+
+// setListArchived archives or unarchives the list named by the list_id
+// parameter. An unarchived list goes back at the end of the live lists.
+func setListArchived(c *gin.Context, archived bool) {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	list, ok := findOwnedList(ctx, c, c.Param("list_id"))
+	if !ok {
+		return
+	}
+	if (list.Archived_at != nil) == archived {
+		if archived {
+			c.JSON(http.StatusConflict, gin.H{"error": "List is already archived"})
+		} else {
+			c.JSON(http.StatusConflict, gin.H{"error": "List is not archived"})
+		}
+		return
+	}
+
+	now := time.Now()
+	if archived {
+		list.Archived_at = &now
+	} else {
+		lists, err := listStore.Find(ctx, list.User_id, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list.Archived_at = nil
+		list.Position = 0
+		if len(lists) > 0 {
+			list.Position = lists[len(lists)-1].Position + 1
+		}
+	}
+	list.Updated_at = now
+	if err := listStore.Update(ctx, list); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating list"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func ArchiveList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setListArchived(c, true)
+	}
+}
+
+func UnarchiveList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setListArchived(c, false)
+	}
+}
+
+// ReorderLists sets the order of the caller's live lists. The body must name
+// each of them exactly once.
+func ReorderLists() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var request models.ReorderListsRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if owner == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "as_user must name a single user when reordering lists"})
+			return
+		}
+
+		lists, err := listStore.Find(ctx, owner, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		remaining := map[primitive.ObjectID]bool{}
+		for _, list := range lists {
+			remaining[list.ID] = true
+		}
+		for _, id := range request.Ids {
+			if !remaining[id] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ids must name each live list exactly once, got " + id.Hex()})
+				return
+			}
+			delete(remaining, id)
+		}
+		if len(remaining) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ids must name each live list exactly once"})
+			return
+		}
+
+		if err := listStore.Reorder(ctx, request.Ids); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reordering lists"})
+			return
+		}
+
+		lists, err = listStore.Find(ctx, owner, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := countListTodos(ctx, owner, lists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": lists})
+	}
+}
+
+// MoveTodo puts a todo in another list of its owner, or in no list.
+func MoveTodo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var request models.MoveTodoRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
+		if !checkTodoList(ctx, c, todo.User_id, request.List_id) {
+			return
+		}
+
+		before := todo
+		todo.List_id = request.List_id
+		todo.Updated_at = time.Now()
+		if err := saveTodo(ctx, c, models.RevisionEdit, before, &todo, nil); err != nil {
+			updateFailed(c, err, "Error moving the todo")
+			return
+		}
+		c.Header("ETag", helper.ETag(todo.Version))
+		c.JSON(http.StatusOK, gin.H{"data": todo})
+	}
+}
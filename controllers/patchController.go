@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// patchable lists the todo fields a PATCH may change. The others are kept by
+// the server, and a patch changing one of them is refused.
+var patchable = map[string]bool{
+	"title":       true,
+	"description": true,
+	"check":       true,
+	"priority":    true,
+	"due_at":      true,
+	"timezone":    true,
+	"tags":        true,
+	"list_id":     true,
+	"items":       true,
+	"auto_check":  true,
+	"recurrence":  true,
+}
+
+// applyTodoPatch applies a patch in the given format to the todo. Only the
+// fields listed in patchable may change.
+func applyTodoPatch(todo models.Todo, format string, patch []byte) (patched models.Todo, err error) {
+	document, err := json.Marshal(todo)
+	if err != nil {
+		return patched, err
+	}
+
+	switch format {
+	case helper.MergePatchType:
+		document, err = helper.MergePatch(document, patch)
+	case helper.JSONPatchType:
+		document, err = helper.JSONPatch(document, patch)
+	default:
+		return patched, &requestError{http.StatusUnsupportedMediaType, "Content-Type must be " + helper.MergePatchType + " or " + helper.JSONPatchType}
+	}
+	if errors.Is(err, helper.ErrInvalidPatch) {
+		return patched, &requestError{http.StatusBadRequest, err.Error()}
+	}
+	if errors.Is(err, helper.ErrPatchTestFailed) {
+		return patched, &requestError{http.StatusConflict, err.Error()}
+	}
+	if err != nil {
+		return patched, &requestError{http.StatusUnprocessableEntity, err.Error()}
+	}
+
+	if err := json.Unmarshal(document, &patched); err != nil {
+		return patched, &requestError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	for _, change := range diffTodos(&todo, &patched) {
+		if !patchable[change.Field] {
+			return patched, &requestError{http.StatusUnprocessableEntity, change.Field + " cannot be patched"}
+		}
+	}
+	// the version is not audited, but saving depends on it
+	patched.Version = todo.Version
+	return patched, nil
+}
+
+// patchTodo applies a patch in the given format to the todo and saves the
+// result, which must pass the same checks as a new todo. It returns the saved
+// todo and the next occurrence checking it may have produced.
+func patchTodo(ctx context.Context, c *gin.Context, todo models.Todo, format string, patch []byte) (patched models.Todo, next *models.Todo, err error) {
+	patched, err = applyTodoPatch(todo, format, patch)
+	if err != nil {
+		return patched, nil, err
+	}
+
+	patched.Title = strings.TrimSpace(patched.Title)
+	if err := validate.Struct(patched); err != nil {
+		return patched, nil, &requestError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	if err := prepareTodo(&patched); err != nil {
+		return patched, nil, &requestError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	if patched.Title != todo.Title {
+		if _, err := todoStore.FindByTitle(ctx, todo.User_id, patched.Title); err == nil {
+			return patched, nil, &requestError{http.StatusConflict, "title is exist on database"}
+		}
+	}
+	if err := verifyTodoTags(ctx, todo.User_id, patched.Tags); err != nil {
+		return patched, nil, err
+	}
+	if !sameList(patched.List_id, todo.List_id) {
+		if err := verifyTodoList(ctx, todo.User_id, patched.List_id); err != nil {
+			return patched, nil, err
+		}
+	}
+
+	// new items get an id, and every item the place it was given
+	for i := range patched.Items {
+		if patched.Items[i].ID.IsZero() {
+			patched.Items[i].ID = primitive.NewObjectID()
+		}
+		patched.Items[i].Position = i
+	}
+
+	// Check goes through setCheck, unless Auto_check derives it from the
+	// items
+	check := patched.Check
+	patched.Check = todo.Check
+	if patched.Auto_check && len(patched.Items) > 0 {
+		next = syncAutoCheck(&patched)
+	} else if check != todo.Check {
+		next = setCheck(&patched, check)
+	}
+	patched.Updated_at = time.Now()
+
+	if err := saveTodo(ctx, c, models.RevisionEdit, todo, &patched, next); err != nil {
+		return patched, nil, err
+	}
+	return patched, next, nil
+}
+
+// PatchTodo changes any combination of the fields of a todo listed in
+// patchable, given as a JSON Merge Patch or a JSON Patch of the todo as it is
+// served.
+func PatchTodo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		patch, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
+
+		patched, next, err := patchTodo(ctx, c, todo, c.ContentType(), patch)
+		if err != nil {
+			if status, _ := errorStatus(err, ""); status == http.StatusUnsupportedMediaType {
+				c.Header("Accept-Patch", helper.MergePatchType+", "+helper.JSONPatchType)
+			}
+			requestFailed(c, err, "Error updating the todo")
+			return
+		}
+		c.Header("ETag", helper.ETag(patched.Version))
+
+		if next != nil {
+			c.JSON(http.StatusOK, gin.H{"data": patched, "next": next})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": patched})
+	}
+}
+
+// sameList reports whether two list ids name the same list, or both none.
+func sameList(a *primitive.ObjectID, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchTodos finds the caller's live todos whose title or description match
+// the q parameter, most relevant first. Words must all appear, "quoted
+// phrases" as written and words ending in * as the start of a word. Each
+// result carries the matched fragments of the title and description.
+func SearchTodos() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		q := strings.TrimSpace(c.Query("q"))
+		if q == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+			return
+		}
+		query := database.ParseSearch(q)
+		if query.Empty() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q has no words to search for"})
+			return
+		}
+
+		limit, err := helper.PageLimit(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hits, err := todoStore.Search(ctx, owner, query, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		results := []gin.H{}
+		for _, hit := range hits {
+			highlights := gin.H{}
+			if title := helper.Highlight(hit.Todo.Title, query, 0); title != "" {
+				highlights["title"] = title
+			}
+			if description := helper.Highlight(hit.Todo.Description, query, helper.SnippetLength); description != "" {
+				highlights["description"] = description
+			}
+			results = append(results, gin.H{"todo": hit.Todo, "score": hit.Score, "highlights": highlights})
+		}
+		c.JSON(http.StatusOK, gin.H{"data": results})
+	}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetTodoStats sums up the live todos of the caller, or of the user an ADMIN
+// names with as_user: open and done counts, the completion rate, the average
+// time to complete a todo, the current streak of days with a todo completed
+// and the todos created and completed each day and week from the from to the
+// to date. Days are calendar days in the timezone of the user.
+func GetTodoStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// days follow the user the stats are about, or the caller for all
+		// users
+		userId := owner
+		if userId == "" {
+			userId = c.GetString("uid")
+		}
+		user, err := userStore.FindById(ctx, userId)
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching user"})
+			return
+		}
+		loc, err := helper.UserLocation(c, user)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		start, end, err := helper.StatsRange(c.Query("from"), c.Query("to"), loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		counter := helper.NewTodoStatsCounter(start, end, loc)
+		err = todoStore.Each(ctx, database.TodoFilter{User_id: owner}, func(todo models.Todo) error {
+			counter.Add(todo)
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": counter.Stats(time.Now())})
+	}
+}
@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var tagStore database.TagStore
+
+// findOwnedTag loads the tag named by the tag_id parameter, reporting tags
+// outside the owner scope of the request as not found. When ok is false the
+// response has already been written.
+func findOwnedTag(ctx context.Context, c *gin.Context, id string) (tag models.Tag, ok bool) {
+	owner, err := helper.TodoOwner(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return tag, false
+	}
+
+	tagID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag ID format"})
+		return tag, false
+	}
+
+	tag, err = tagStore.FindById(ctx, tagID)
+	if err == nil && owner != "" && tag.User_id != owner {
+		err = database.ErrNotFound
+	}
+	if err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+			return tag, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error accessing the database"})
+		return tag, false
+	}
+	return tag, true
+}
+
+// verifyTodoTags makes sure every tag of a todo exists for its owner.
+func verifyTodoTags(ctx context.Context, userId string, tags []string) error {
+	for _, name := range tags {
+		_, err := tagStore.FindByName(ctx, userId, name)
+		if err == database.ErrNotFound {
+			return &requestError{http.StatusBadRequest, "unknown tag " + name + ", create it under /tags first"}
+		}
+		if err != nil {
+			return &requestError{http.StatusInternalServerError, "Error accessing the database"}
+		}
+	}
+	return nil
+}
+
+// retagTodos replaces the tag from with into on every todo of the user
+// carrying it, trashed ones included, or only removes it when into is empty.
+// Each todo is saved at its next version with a revision.
+func retagTodos(ctx context.Context, c *gin.Context, userId string, from string, into string) error {
+	todos, err := todoStore.Find(ctx, database.TodoFilter{
+		User_id:        userId,
+		IncludeTrashed: true,
+		Tags:           []string{from},
+	})
+	if err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		before := todo
+		todo.Tags = []string{}
+		for _, tag := range before.Tags {
+			if tag != from && tag != into {
+				todo.Tags = append(todo.Tags, tag)
+			}
+		}
+		if into != "" {
+			todo.Tags = append(todo.Tags, into)
+		}
+		if err := saveTodo(ctx, c, models.RevisionEdit, before, &todo, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagChangeFailed answers a request whose change of a tag and of the todos
+// carrying it failed.
+func tagChangeFailed(c *gin.Context, err error, message string) {
+	switch err {
+	case database.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+	case database.ErrVersionConflict:
+		c.JSON(http.StatusConflict, gin.H{"error": "a todo carrying the tag changed at the same time, try again"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// checkTodoTags is verifyTodoTags for handlers. When ok is false the response
+// has already been written.
+func checkTodoTags(ctx context.Context, c *gin.Context, userId string, tags []string) (ok bool) {
+	if err := verifyTodoTags(ctx, userId, tags); err != nil {
+		requestFailed(c, err, "Error accessing the database")
+		return false
+	}
+	return true
+}
+
+func GetTags() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tags, err := tagStore.Find(ctx, owner)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": tags})
+	}
+}
+
+func GetTagById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		tag, ok := findOwnedTag(ctx, c, c.Param("tag_id"))
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": tag})
+	}
+}
+
+func AddTag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var tag models.Tag
+		if err := c.BindJSON(&tag); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tag.Name = strings.TrimSpace(tag.Name)
+		if err := validate.Struct(tag); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if owner == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "as_user must name a single user when creating a tag"})
+			return
+		}
+
+		if _, err := tagStore.FindByName(ctx, owner, tag.Name); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "a tag with this name already exists"})
+			return
+		}
+
+		tag.ID = primitive.NewObjectID()
+		tag.User_id = owner
+		tag.Created_at = time.Now()
+		tag.Updated_at = tag.Created_at
+		err = tagStore.Insert(ctx, tag)
+		if err == database.ErrDuplicate {
+			c.JSON(http.StatusConflict, gin.H{"error": "a tag with this name already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Tag not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"InsertedID": tag.ID}})
+	}
+}
+
+// UpdateTag changes the name and color of a tag. A new name is applied to
+// every todo carrying the tag; renaming onto an existing tag is refused, since
+// that is what merging is for.
+func UpdateTag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var update models.Tag
+		if err := c.BindJSON(&update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		update.Name = strings.TrimSpace(update.Name)
+		if err := validate.Struct(update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tag, ok := findOwnedTag(ctx, c, c.Param("tag_id"))
+		if !ok {
+			return
+		}
+
+		if update.Name != tag.Name {
+			if _, err := tagStore.FindByName(ctx, tag.User_id, update.Name); err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "a tag with this name already exists, merge into it instead"})
+				return
+			}
+		}
+
+		name := tag.Name
+		tag.Name = update.Name
+		tag.Color = update.Color
+		tag.Updated_at = time.Now()
+		err := transactions.Transaction(ctx, func(ctx context.Context) error {
+			if tag.Name != name {
+				if err := retagTodos(ctx, c, tag.User_id, name, tag.Name); err != nil {
+					return err
+				}
+			}
+			return tagStore.Update(ctx, tag)
+		})
+		if err == database.ErrDuplicate {
+			c.JSON(http.StatusConflict, gin.H{"error": "a tag with this name already exists, merge into it instead"})
+			return
+		}
+		if err != nil {
+			tagChangeFailed(c, err, "Error updating tag")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": tag})
+	}
+}
+
+// MergeTag moves every todo tagged with the tag to the tag named in the body
+// and deletes the merged tag.
+func MergeTag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var request models.MergeTagRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		from, ok := findOwnedTag(ctx, c, c.Param("tag_id"))
+		if !ok {
+			return
+		}
+		into, ok := findOwnedTag(ctx, c, request.Into)
+		if !ok {
+			return
+		}
+		if into.User_id != from.User_id {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+			return
+		}
+		if into.ID == from.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot merge a tag into itself"})
+			return
+		}
+
+		err := transactions.Transaction(ctx, func(ctx context.Context) error {
+			if err := retagTodos(ctx, c, from.User_id, from.Name, into.Name); err != nil {
+				return err
+			}
+			return tagStore.Delete(ctx, from)
+		})
+		if err != nil {
+			tagChangeFailed(c, err, "Error merging tags")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": into})
+	}
+}
+
+func DeleteTag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		tag, ok := findOwnedTag(ctx, c, c.Param("tag_id"))
+		if !ok {
+			return
+		}
+
+		err := transactions.Transaction(ctx, func(ctx context.Context) error {
+			if err := retagTodos(ctx, c, tag.User_id, tag.Name, ""); err != nil {
+				return err
+			}
+			return tagStore.Delete(ctx, tag)
+		})
+		if err != nil {
+			tagChangeFailed(c, err, "Error deleting tag")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+	}
+}
@@ -2,60 +2,379 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"nitiwat/database"
 	helper "nitiwat/helpers"
 	"nitiwat/models"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var todoCollections *mongo.Collection = database.OpenCollection(database.Client, "todos")
+var todoStore database.TodoStore
+
+// findOwnedTodo loads the todo named by the todo_id parameter. Todos outside
+// the owner scope of the request are reported as not found so their existence
+// does not leak. When ok is false the response has already been written.
+func findOwnedTodo(ctx context.Context, c *gin.Context) (todo models.Todo, ok bool) {
+	owner, err := helper.TodoOwner(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return todo, false
+	}
+
+	todoID, err := primitive.ObjectIDFromHex(c.Param("todo_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID format"})
+		return todo, false
+	}
+
+	todo, err = todoStore.FindById(ctx, todoID)
+	if err == nil && owner != "" && todo.User_id != owner {
+		err = database.ErrNotFound
+	}
+	if err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+			return todo, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error accessing the database"})
+		return todo, false
+	}
+	return todo, true
+}
+
+// findLiveTodo is findOwnedTodo for todos that are not in the trash.
+func findLiveTodo(ctx context.Context, c *gin.Context) (todo models.Todo, ok bool) {
+	todo, ok = findOwnedTodo(ctx, c)
+	if ok && todo.Deleted_at != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		return todo, false
+	}
+	return todo, ok
+}
+
+// checkIfMatch checks the If-Match header of a request changing a todo or
+// user at the given version. When ok is false the response has already been
+// written.
+func checkIfMatch(c *gin.Context, version int64) (ok bool) {
+	switch err := helper.CheckIfMatch(c, version); err {
+	case nil:
+		return true
+	case helper.ErrPreconditionRequired:
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	}
+	return false
+}
+
+// updateFailed answers a request whose update of a todo or user failed. A
+// version conflict means another request saved it first.
+func updateFailed(c *gin.Context, err error, message string) {
+	if err == database.ErrVersionConflict {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": helper.ErrPreconditionFailed.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// requestError is an error the request is answered with, with its status.
+// Functions shared by several handlers return it instead of writing the
+// response themselves.
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// errorStatus returns the status and message to answer an error with. Errors
+// other than request errors and version conflicts are answered with status
+// 500 and the given message.
+func errorStatus(err error, message string) (int, string) {
+	if e, ok := err.(*requestError); ok {
+		return e.status, e.message
+	}
+	if err == database.ErrVersionConflict {
+		return http.StatusPreconditionFailed, helper.ErrPreconditionFailed.Error()
+	}
+	return http.StatusInternalServerError, message
+}
+
+// requestFailed answers a request with an error returned by a shared function.
+func requestFailed(c *gin.Context, err error, message string) {
+	status, message := errorStatus(err, message)
+	c.JSON(status, gin.H{"error": message})
+}
+
+// sortableTodoFields whitelists the fields the sort query parameter accepts.
+var sortableTodoFields = map[string]bool{
+	"due_at":     true,
+	"created_at": true,
+	"updated_at": true,
+	"priority":   true,
+	"title":      true,
+	"check":      true,
+	"position":   true,
+}
+
+// todoListParams whitelists the query parameters of the todo list endpoints.
+var todoListParams = map[string]bool{
+	"as_user":        true,
+	"check":          true,
+	"priority":       true,
+	"created_after":  true,
+	"created_before": true,
+	"updated_after":  true,
+	"updated_before": true,
+	"q":              true,
+	"sort":           true,
+	"tags":           true,
+	"tags_match":     true,
+	"list_id":        true,
+	"limit":          true,
+	"after":          true,
+}
+
+// parseTodoSort reads sort=field,-field from the query; a leading - sorts
+// that field descending. Without it todos come in position order.
+func parseTodoSort(c *gin.Context) ([]database.SortField, error) {
+	fields := []database.SortField{}
+	param := c.Query("sort")
+	if param == "" {
+		return append(fields, database.SortField{Field: "position"}), nil
+	}
+
+	for _, name := range strings.Split(param, ",") {
+		field := database.SortField{Field: strings.TrimPrefix(name, "-"), Desc: strings.HasPrefix(name, "-")}
+		if !sortableTodoFields[field.Field] {
+			return nil, errors.New("cannot sort by " + name)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func parseTimeParam(c *gin.Context, name string) (*time.Time, error) {
+	param := c.Query(name)
+	if param == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return nil, errors.New(name + " must be an RFC 3339 time")
+	}
+	return &t, nil
+}
+
+// parseTodoQuery turns the query parameters of a todo list request into a
+// store filter, rejecting parameters and values outside the whitelist. The
+// owner is left for the caller to set.
+func parseTodoQuery(c *gin.Context) (database.TodoFilter, error) {
+	var filter database.TodoFilter
+
+	for name := range c.Request.URL.Query() {
+		if !todoListParams[name] {
+			return filter, errors.New("unknown query parameter " + name)
+		}
+	}
+
+	if param := c.Query("check"); param != "" {
+		check, err := strconv.ParseBool(param)
+		if err != nil {
+			return filter, errors.New("check must be true or false")
+		}
+		filter.Check = &check
+	}
+
+	if param := c.Query("priority"); param != "" {
+		for _, value := range strings.Split(param, ",") {
+			priority, err := strconv.Atoi(value)
+			if err != nil || priority < models.PriorityNone || priority > models.PriorityHigh {
+				return filter, errors.New("priority must be a list of numbers between 0 and 3")
+			}
+			filter.Priorities = append(filter.Priorities, priority)
+		}
+	}
+
+	var err error
+	if filter.Created_after, err = parseTimeParam(c, "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.Created_before, err = parseTimeParam(c, "created_before"); err != nil {
+		return filter, err
+	}
+	if filter.Updated_after, err = parseTimeParam(c, "updated_after"); err != nil {
+		return filter, err
+	}
+	if filter.Updated_before, err = parseTimeParam(c, "updated_before"); err != nil {
+		return filter, err
+	}
+
+	filter.Text = strings.TrimSpace(c.Query("q"))
+
+	if param := c.Query("tags"); param != "" {
+		for _, name := range strings.Split(param, ",") {
+			filter.Tags = append(filter.Tags, strings.TrimSpace(name))
+		}
+	}
+	if param := c.Query("list_id"); param != "" {
+		listID, err := primitive.ObjectIDFromHex(param)
+		if err != nil {
+			return filter, errors.New("list_id must be a list id")
+		}
+		filter.List_id = &listID
+	}
+
+	switch c.Query("tags_match") {
+	case "", "any":
+	case "all":
+		filter.AllTags = true
+	default:
+		return filter, errors.New("tags_match must be any or all")
+	}
+
+	if filter.Sort, err = parseTodoSort(c); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseTodoPage reads the limit and after parameters of a paginated todo
+// listing into filter. It must run after the sort has been parsed, since a
+// cursor is only valid for the sort order it was issued for.
+func parseTodoPage(c *gin.Context, filter *database.TodoFilter) error {
+	limit, err := helper.PageLimit(c)
+	if err != nil {
+		return err
+	}
+	filter.Limit = limit
+
+	if param := c.Query("after"); param != "" {
+		after, err := database.DecodeTodoCursor(param, filter.Sort)
+		if err != nil {
+			return errors.New("after is not a cursor for this sort order")
+		}
+		filter.After = after
+	}
+	return nil
+}
+
+// findTodoPage returns one page of the todos matched by filter and the cursor
+// of the next page, which is nil on the last page.
+func findTodoPage(ctx context.Context, filter database.TodoFilter) ([]models.Todo, *string, error) {
+	filter.Limit++
+	todos, err := todoStore.Find(ctx, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(todos)) < filter.Limit {
+		return todos, nil, nil
+	}
+
+	todos = todos[:len(todos)-1]
+	next := database.EncodeTodoCursor(filter.Sort, todos[len(todos)-1])
+	return todos, &next, nil
+}
+
+// prepareTodo validates the client supplied fields of a todo, stores its due
+// date in UTC and drops blank and repeated tags.
+func prepareTodo(todo *models.Todo) error {
+	if err := validate.Var(todo.Timezone, "omitempty,timezone"); err != nil {
+		return errors.New("unknown timezone " + todo.Timezone)
+	}
+	if err := validate.Var(todo.Priority, "min=0,max=3"); err != nil {
+		return errors.New("priority must be between 0 and 3")
+	}
+	if todo.Due_at != nil {
+		due := todo.Due_at.UTC()
+		todo.Due_at = &due
+	}
+
+	for _, item := range todo.Items {
+		if err := validate.Struct(item); err != nil {
+			return errors.New("checklist items need a title of at most 200 characters")
+		}
+	}
+
+	if todo.Recurrence != nil {
+		if err := validate.Struct(todo.Recurrence); err != nil {
+			return err
+		}
+		if err := helper.CheckRecurrence(todo.Recurrence); err != nil {
+			return err
+		}
+	}
+
+	tags := []string{}
+	for _, name := range todo.Tags {
+		name = strings.TrimSpace(name)
+		if name != "" && !containsTag(tags, name) {
+			tags = append(tags, name)
+		}
+	}
+	todo.Tags = tags
+	return nil
+}
+
+func containsTag(tags []string, name string) bool {
+	for _, tag := range tags {
+		if tag == name {
+			return true
+		}
+	}
+	return false
+}
 
 func GetTodo() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
 
-		cursor, err := todoCollections.Find(ctx, bson.M{})
+		owner, err := helper.TodoOwner(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter, err := parseTodoQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.User_id = owner
+		if err := parseTodoPage(c, &filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var todos []bson.M
-		// fmt.Println("cursor", cursor)
-		if err = cursor.All(ctx, &todos); err != nil {
+
+		todos, next, err := findTodoPage(ctx, filter)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"data": todos})
+		c.JSON(http.StatusOK, gin.H{"data": todos, "next_cursor": next})
 	}
 }
 
 func GetTodoById() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
 
-		todoIDParam := c.Param("todo_id")
-		todoID, err := primitive.ObjectIDFromHex(todoIDParam)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID format"})
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok {
 			return
 		}
-
-		var todo models.Todo
-		err = todoCollections.FindOne(ctx, bson.M{"id": todoID}).Decode(&todo)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
-				return
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error accessing the database"})
+		if helper.NotModified(c, todo.Version) {
+			c.Status(http.StatusNotModified)
 			return
 		}
 
@@ -63,97 +382,488 @@ func GetTodoById() gin.HandlerFunc {
 	}
 }
 
+// nextTodoPosition returns the position that puts a new todo of the user
+// after all of their todos. Once appending has made the positions longer than
+// helper.MaxRankLength, the user's todos are ranked again first.
+func nextTodoPosition(ctx context.Context, userId string) (string, error) {
+	filter := database.TodoFilter{
+		User_id:        userId,
+		IncludeTrashed: true,
+		Sort:           []database.SortField{{Field: "position", Desc: true}},
+		Limit:          1,
+	}
+	last, err := todoStore.Find(ctx, filter)
+	if err != nil || len(last) == 0 {
+		return helper.RankAfter(""), err
+	}
+	next := helper.RankAfter(last[0].Position)
+	if len(next) <= helper.MaxRankLength {
+		return next, nil
+	}
+
+	filter.Sort[0].Desc = false
+	filter.Limit = 0
+	todos, err := todoStore.Find(ctx, filter)
+	if err != nil {
+		return "", err
+	}
+	if err := rerankTodos(ctx, todos); err != nil {
+		return "", err
+	}
+	return helper.RankAfter(todos[len(todos)-1].Position), nil
+}
+
+// rerankTodos gives the todos, in their order, evenly spread positions and
+// saves each at its next version, in one transaction. No revision is
+// recorded: ranking again keeps the order and is not a change a user made.
+func rerankTodos(ctx context.Context, todos []models.Todo) error {
+	ranks := helper.SpreadRanks(len(todos))
+	ranked := make([]models.Todo, len(todos))
+	err := transactions.Transaction(ctx, func(ctx context.Context) error {
+		// a transaction may be retried from the start
+		copy(ranked, todos)
+		for i := range ranked {
+			ranked[i].Position = ranks[i]
+			ranked[i].Version++
+			if err := todoStore.Update(ctx, ranked[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		copy(todos, ranked)
+	}
+	return err
+}
+
+// createTodo adds a new todo of the owner of the request, whose fields other
+// than the ones a client sets are filled in here.
+func createTodo(ctx context.Context, c *gin.Context, todo models.Todo) (models.Todo, error) {
+	if err := prepareTodo(&todo); err != nil {
+		return todo, &requestError{http.StatusBadRequest, err.Error()}
+	}
+
+	// the owner comes from the token, never from the body
+	owner, err := helper.TodoOwner(c)
+	if err != nil {
+		return todo, &requestError{http.StatusBadRequest, err.Error()}
+	}
+	if owner == "" {
+		return todo, &requestError{http.StatusBadRequest, "as_user must name a single user when creating a todo"}
+	}
+
+	//find todo by title
+	_, errTodo := todoStore.FindByTitle(ctx, owner, todo.Title)
+	if errTodo == nil {
+		return todo, &requestError{http.StatusConflict, "title is exist on database"}
+	}
+
+	foundUser, err := userStore.FindById(ctx, owner)
+	if err != nil {
+		return todo, &requestError{http.StatusInternalServerError, "ID is not exist on database"}
+	}
+	if err := verifyTodoTags(ctx, owner, todo.Tags); err != nil {
+		return todo, err
+	}
+	if err := verifyTodoList(ctx, owner, todo.List_id); err != nil {
+		return todo, err
+	}
+
+	todo.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	todo.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	todo.Position, err = nextTodoPosition(ctx, foundUser.User_id)
+	if err != nil {
+		return todo, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	todo.ID = primitive.NewObjectID()
+	todo.User_id = foundUser.User_id
+	todo.Check = false
+	todo.Completed_at = nil
+	todo.Series_id = nil
+	todo.Version = 0
+	if todo.Recurrence != nil {
+		series := todo.ID
+		todo.Series_id = &series
+	}
+	for i := range todo.Items {
+		todo.Items[i].ID = primitive.NewObjectID()
+		todo.Items[i].Position = i
+		todo.Items[i].Check = false
+	}
+	err = transactions.Transaction(ctx, func(ctx context.Context) error {
+		if err := todoStore.Insert(ctx, todo); err != nil {
+			return err
+		}
+		return recordRevision(ctx, c, models.RevisionCreate, nil, todo)
+	})
+	if err != nil {
+		return todo, &requestError{http.StatusInternalServerError, "Todo not created"}
+	}
+	return todo, nil
+}
+
 func AddTodo() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
 		var todo models.Todo
-		var foundTodo models.Todo
-		var foundUser models.User
 
 		if err := c.BindJSON(&todo); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		//find todo by title
-		filter := bson.M{"title": todo.Title, "user_id": todo.User_id}
-		errTodo := todoCollections.FindOne(ctx, filter).Decode(&foundTodo)
+		todo, err := createTodo(ctx, c, todo)
+		if err != nil {
+			requestFailed(c, err, "Todo not created")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"InsertedID": todo.ID}})
+
+	}
+}
+
+// deleteTodoPermanently removes a todo for good and records a delete revision
+// for it in the same transaction. The history of the todo is kept.
+func deleteTodoPermanently(ctx context.Context, c *gin.Context, todo models.Todo) error {
+	return transactions.Transaction(ctx, func(ctx context.Context) error {
+		if err := todoStore.Delete(ctx, todo.ID); err != nil {
+			return err
+		}
+		return recordRevision(ctx, c, models.RevisionDelete, &todo, todo)
+	})
+}
+
+func DeleteTodo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
-		if errTodo == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "title is exist on database"})
+
+		//check if have todo id in database
+		todo, ok := findOwnedTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
 			return
 		}
 
-		err := userCollections.FindOne(ctx, bson.M{"user_id": todo.User_id}).Decode(&foundUser)
+		if c.Query("permanent") == "true" {
+			if err := deleteTodoPermanently(ctx, c, todo); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting the todo"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"message": todo.ID.Hex() + " todo deleted permanently"})
+			return
+		}
+
+		if todo.Deleted_at != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Todo is already in the trash"})
+			return
+		}
+
+		before := todo
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		todo.Deleted_at = &now
+		if err := saveTodo(ctx, c, models.RevisionDelete, before, &todo, nil); err != nil {
+			updateFailed(c, err, "Error deleting the todo")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": todo.ID.Hex() + " todo moved to the trash"})
+	}
+}
+
+func GetTrash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
+
+		owner, err := helper.TodoOwner(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		todos, err := todoStore.Find(ctx, database.TodoFilter{User_id: owner, Trashed: true})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "ID is not exist on database"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{"data": todos})
+	}
+}
 
-		todo.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		todo.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		todo.ID = primitive.NewObjectID()
-		todo.User_id = foundUser.User_id
-		todo.Check = false
-		resultInsertionTodo, insertErr := todoCollections.InsertOne(ctx, todo)
+func RestoreTodo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
 
-		if insertErr != nil {
-			msg := "Todo not created"
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		todo, ok := findOwnedTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
 			return
+		}
 
+		if todo.Deleted_at == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Todo is not in the trash"})
+			return
 		}
-		defer cancel()
 
-		c.JSON(http.StatusOK, gin.H{"data": resultInsertionTodo})
+		if _, err := todoStore.FindByTitle(ctx, todo.User_id, todo.Title); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "title is exist on database"})
+			return
+		}
+
+		before := todo
+		todo.Deleted_at = nil
+		if err := saveTodo(ctx, c, models.RevisionRestore, before, &todo, nil); err != nil {
+			updateFailed(c, err, "Error restoring the todo")
+			return
+		}
+		c.Header("ETag", helper.ETag(todo.Version))
+
+		c.JSON(http.StatusOK, gin.H{"data": todo})
+	}
+}
 
+// indexOfTodo returns the index of the todo with the given id, or -1.
+func indexOfTodo(todos []models.Todo, id primitive.ObjectID) int {
+	for i, todo := range todos {
+		if todo.ID == id {
+			return i
+		}
 	}
+	return -1
 }
 
-func DeleteTodo() gin.HandlerFunc {
+// strictlyRanked reports whether todos sorted by position all have distinct
+// positions. Todos created before positions existed have none, and two todos
+// created at the same time can get the same one.
+func strictlyRanked(todos []models.Todo) bool {
+	for i, todo := range todos {
+		if todo.Position == "" || (i > 0 && todos[i-1].Position == todo.Position) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReorderTodo moves a todo between two neighbours by giving it a position
+// between theirs. No other todo is touched unless the positions of the user's
+// todos are not distinct, in which case they are ranked again in their
+// current order first.
+func ReorderTodo() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
 
-		if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+		var request models.ReorderTodoRequest
+		if err := c.BindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if request.Before == nil && request.After == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before or after is required"})
+			return
+		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
 
-		todoIDParam := c.Param("todo_id")
-		todoID, err := primitive.ObjectIDFromHex(todoIDParam)
+		todos, err := todoStore.Find(ctx, database.TodoFilter{
+			User_id: todo.User_id,
+			Sort:    []database.SortField{{Field: "position"}},
+		})
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID format"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if !strictlyRanked(todos) {
+			if err := rerankTodos(ctx, todos); err != nil {
+				updateFailed(c, err, "Error ranking the todos")
+				return
+			}
+		}
 
-		var todo models.Todo
-		//check if have todo id in database
-		errTodo := todoCollections.FindOne(ctx, bson.M{"id": todoID}).Decode(&todo)
-		if errTodo != nil {
-			if errTodo == mongo.ErrNoDocuments {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error accessing the database"})
+		// the neighbours are looked up without the moved todo, which may have
+		// just been ranked again. It is missing when it was deleted since it
+		// was found.
+		i := indexOfTodo(todos, todo.ID)
+		if i < 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+			return
+		}
+		todo = todos[i]
+		todos = append(todos[:i], todos[i+1:]...)
+
+		lo, hi := "", ""
+		before, after := -1, -1
+		if request.Before != nil {
+			if before = indexOfTodo(todos, *request.Before); before < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "before must be another live todo of the same user"})
+				return
 			}
+			hi = todos[before].Position
+			if before > 0 {
+				lo = todos[before-1].Position
+			}
+		}
+		if request.After != nil {
+			if after = indexOfTodo(todos, *request.After); after < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "after must be another live todo of the same user"})
+				return
+			}
+			lo = todos[after].Position
+			hi = ""
+			if after+1 < len(todos) {
+				hi = todos[after+1].Position
+			}
+		}
+		if request.Before != nil && request.After != nil && after+1 != before {
+			c.JSON(http.StatusConflict, gin.H{"error": "after and before are not next to each other"})
 			return
 		}
 
-		result := todoCollections.FindOneAndDelete(ctx, bson.M{"id": todoID})
-		if result != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting the todo"})
+		moved := todo
+		todo.Position = helper.RankBetween(lo, hi)
+		todo.Updated_at = time.Now()
+		if err := saveTodo(ctx, c, models.RevisionEdit, moved, &todo, nil); err != nil {
+			updateFailed(c, err, "Error moving the todo")
+			return
+		}
+		c.Header("ETag", helper.ETag(todo.Version))
+		c.JSON(http.StatusOK, gin.H{"data": todo})
+	}
+}
+
+// GetTodoOccurrences lists every live occurrence of the recurring todo the
+// todo belongs to, completed ones included, by due date.
+func GetTodoOccurrences() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok {
+			return
+		}
+		if todo.Series_id == nil {
+			c.JSON(http.StatusOK, gin.H{"data": []models.Todo{todo}})
+			return
+		}
+
+		todos, err := todoStore.Find(ctx, database.TodoFilter{
+			User_id:   todo.User_id,
+			Series_id: todo.Series_id,
+			Sort:      []database.SortField{{Field: "due_at"}, {Field: "created_at"}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{"data": todos})
+	}
+}
 
-		c.JSON(http.StatusOK, gin.H{"message": todoIDParam + "todo deleted successfully"})
+// setCheck checks or unchecks a todo. Every change of Check goes through it.
+// Checking an open recurring todo hands its recurrence on to a new todo for
+// the next occurrence, which is returned for the caller to save with
+// saveTodo, which also positions it; the checked todo stays behind as
+// history.
+func setCheck(todo *models.Todo, check bool) (next *models.Todo) {
+	now := time.Now()
+	wasChecked := todo.Check
+	todo.Check = check
+	todo.Updated_at = now
+	if !check {
+		todo.Completed_at = nil
+		return nil
+	}
+	if wasChecked {
+		return nil
+	}
+	todo.Completed_at = &now
+	if todo.Recurrence == nil {
+		return nil
+	}
+
+	loc := time.UTC
+	if todo.Timezone != "" {
+		if l, err := time.LoadLocation(todo.Timezone); err == nil {
+			loc = l
+		}
+	}
+	if todo.Series_id == nil {
+		series := todo.ID
+		todo.Series_id = &series
+	}
+
+	occurrence := *todo
+	due := helper.NextDue(*todo.Recurrence, todo.Due_at, now, loc).UTC()
+	occurrence.ID = primitive.NewObjectID()
+	occurrence.Check = false
+	occurrence.Completed_at = nil
+	occurrence.Due_at = &due
+	occurrence.Created_at = now
+	occurrence.Updated_at = now
+	// the occurrence is a new document, not a later version of the todo
+	occurrence.Version = 0
+	occurrence.Position = ""
+	occurrence.Items = nil
+	for _, item := range todo.Items {
+		item.Check = false
+		occurrence.Items = append(occurrence.Items, item)
+	}
+	occurrence.Tags = append([]string{}, todo.Tags...)
+	todo.Recurrence = nil
+	return &occurrence
+}
+
+// saveTodo stores a changed todo at its next version and then the next
+// occurrence setCheck may have produced for it, recording a revision for
+// each, all in one transaction.
+func saveTodo(ctx context.Context, c *gin.Context, action string, before models.Todo, todo *models.Todo, next *models.Todo) error {
+	todo.Version++
+	saved := *todo
+	err := transactions.Transaction(ctx, func(ctx context.Context) error {
+		// a transaction may be retried from the start
+		saved = *todo
+		if err := todoStore.Update(ctx, saved); err != nil {
+			return err
+		}
+		if err := recordRevision(ctx, c, action, &before, saved); err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+
+		// the occurrence goes after all of the user's todos, which may first
+		// rank them again, the saved todo included
+		position, err := nextTodoPosition(ctx, next.User_id)
+		if err != nil {
+			return err
+		}
+		next.Position = position
+		if saved, err = todoStore.FindById(ctx, todo.ID); err != nil {
+			return err
+		}
+		if err := todoStore.Insert(ctx, *next); err != nil {
+			return err
+		}
+		return recordRevision(ctx, c, models.RevisionCreate, nil, *next)
+	})
+	if err == nil {
+		*todo = saved
 	}
+	return err
 }
 
 func UpdateCheck() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
 
 		var updateTodo models.UpdateTodo
@@ -162,38 +872,31 @@ func UpdateCheck() gin.HandlerFunc {
 			return
 		}
 
-		todoIDParam := c.Param("todo_id")
-		todoID, err := primitive.ObjectIDFromHex(todoIDParam)
-
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID format"})
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
 			return
 		}
 
-		//check if the param and todo id is match
-
-		var todo models.Todo
-		err = todoCollections.FindOne(ctx, bson.M{"id": todoID, "user_id": updateTodo.User_id}).Decode(&todo)
+		before := todo
+		next := setCheck(&todo, updateTodo.Check)
+		err := saveTodo(ctx, c, checkAction(updateTodo.Check), before, &todo, next)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Todo or user_id is not match"})
+			updateFailed(c, err, "Error updating the todo")
 			return
 		}
+		c.Header("ETag", helper.ETag(todo.Version))
 
-		filter := bson.M{"id": todoID}
-		update := bson.M{"$set": bson.M{"check": updateTodo.Check}}
-		_, err = todoCollections.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating the todo"})
+		if next != nil {
+			c.JSON(http.StatusOK, gin.H{"data": "update check successfully", "next": next})
 			return
 		}
-
 		c.JSON(http.StatusOK, gin.H{"data": "update check successfully"})
 	}
 }
 
 func UpdateEditTodo() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
 
 		var updateTodo models.Todo
@@ -202,41 +905,42 @@ func UpdateEditTodo() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID format"})
 			return
 		}
-
-		todoIDParam := c.Param("todo_id")
-		todoID, errParam := primitive.ObjectIDFromHex(todoIDParam)
-		if errParam != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params format"})
+		if err := prepareTodo(&updateTodo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Check if the param and todo id match
-		var todo models.Todo
-		err := todoCollections.FindOne(ctx, bson.M{"id": todoID, "user_id": updateTodo.User_id}).Decode(&todo)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Todo or user_id does not match"})
+		todo, ok := findLiveTodo(ctx, c)
+		if !ok || !checkIfMatch(c, todo.Version) {
+			return
+		}
+		if !checkTodoTags(ctx, c, todo.User_id, updateTodo.Tags) {
 			return
 		}
 
 		// Update the todo item
-		update := bson.M{
-			"$set": bson.M{
-				"title":       updateTodo.Title,
-				"description": updateTodo.Description,
-				"updated_at":  time.Now(),
-			},
-		}
+		before := todo
+		todo.Title = updateTodo.Title
+		todo.Description = updateTodo.Description
+		todo.Priority = updateTodo.Priority
+		todo.Due_at = updateTodo.Due_at
+		todo.Timezone = updateTodo.Timezone
+		todo.Tags = updateTodo.Tags
+		todo.Auto_check = updateTodo.Auto_check
+		todo.Recurrence = updateTodo.Recurrence
+		next := syncAutoCheck(&todo)
+		todo.Updated_at = time.Now()
 
-		result, err := todoCollections.UpdateOne(ctx, bson.M{"id": todoID}, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating todo"})
+		err := saveTodo(ctx, c, models.RevisionEdit, before, &todo, next)
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No todo found to update"})
 			return
 		}
-
-		if result.MatchedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "No todo found to update"})
+		if err != nil {
+			updateFailed(c, err, "Error updating todo")
 			return
 		}
+		c.Header("ETag", helper.ETag(todo.Version))
 
 		c.JSON(http.StatusOK, gin.H{"message": "Todo updated successfully"})
 	}
@@ -244,110 +948,120 @@ func UpdateEditTodo() gin.HandlerFunc {
 
 func GetTodoByUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
 
 		userID := c.Param("user_id")
-		var todos []bson.M
-
-		// Get the total count of todos for the user
-		count, err := todoCollections.CountDocuments(ctx, bson.M{"user_id": userID})
+		if userID != c.GetString("uid") && helper.CheckUserType(c, "ADMIN") != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		filter, err := parseTodoQuery(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		//filter that match user_id
-		pipeline := mongo.Pipeline{
-			bson.D{{"$match", bson.D{{"user_id", userID}}}},
+		filter.User_id = userID
+		if err := parseTodoPage(c, &filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		cursor, err := todoCollections.Aggregate(ctx, pipeline)
+		// Get the total count of todos for the user
+		count, err := todoStore.Count(ctx, filter)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		if err = cursor.All(ctx, &todos); err != nil {
+		todos, next, err := findTodoPage(ctx, filter)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
 		// Include the count in the response
-		c.JSON(http.StatusOK, gin.H{"data": todos, "total_count": count})
+		c.JSON(http.StatusOK, gin.H{"data": todos, "total_count": count, "next_cursor": next})
 	}
 }
 
-func CheckALlTodoActive() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
+// listDueTodos responds with the open todos of the request's owner due in
+// [after, before), computed in the requesting user's timezone. bounds gets
+// the start of the user's current day and returns the range.
+func listDueTodos(c *gin.Context, bounds func(today time.Time) (after *time.Time, before *time.Time)) {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
 
-		var todos []bson.M
+	owner, err := helper.TodoOwner(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		pipeline := mongo.Pipeline{
-			bson.D{
-				{"$facet", bson.D{
-					{"details", bson.A{bson.D{{"$match", bson.D{{"check", true}}}}}}, // Correctly placed
-					{"totalCount", bson.A{bson.D{{"$count", "total_count"}}}},        // Correctly formatted
-				}},
-			},
-		}
+	user, err := userStore.FindById(ctx, c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching user"})
+		return
+	}
+	loc, err := helper.UserLocation(c, user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		cursor, err := todoCollections.Aggregate(ctx, pipeline)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	today := helper.StartOfDay(time.Now(), loc)
+	after, before := bounds(today)
 
-		if err = cursor.All(ctx, &todos); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	unchecked := false
+	todos, err := todoStore.Find(ctx, database.TodoFilter{
+		User_id:    owner,
+		Check:      &unchecked,
+		Due_after:  after,
+		Due_before: before,
+		Sort:       []database.SortField{{Field: "due_at"}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{"data": todos})
+	c.JSON(http.StatusOK, gin.H{"data": todos, "timezone": loc.String()})
+}
 
+func GetOverdueTodos() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listDueTodos(c, func(today time.Time) (*time.Time, *time.Time) {
+			now := time.Now()
+			return nil, &now
+		})
 	}
 }
 
-func FindQuery() gin.HandlerFunc {
+func GetTodayTodos() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var todos []bson.M
-
-		pipeline := bson.A{
-			bson.D{{"$match", bson.D{{"check", false}}}},
-			bson.D{
-				{"$group",
-					bson.D{
-						{"_id", primitive.Null{}},
-						{"check", bson.D{{"$sum", 1}}},
-					},
-				},
-			},
-			bson.D{
-				{"$project",
-					bson.D{
-						{"_id", 0},
-						{"check", 1},
-					},
-				},
-			},
-		}
-
-		cursor, err := todoCollections.Aggregate(ctx, pipeline)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+		listDueTodos(c, func(today time.Time) (*time.Time, *time.Time) {
+			tomorrow := today.AddDate(0, 0, 1)
+			return &today, &tomorrow
+		})
+	}
+}
 
-		if err = cursor.All(ctx, &todos); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+func GetUpcomingTodos() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		days := 7
+		if param := c.Query("days"); param != "" {
+			var err error
+			days, err = strconv.Atoi(param)
+			if err != nil || days < 1 || days > 365 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a number between 1 and 365"})
+				return
+			}
 		}
 
-		c.JSON(http.StatusOK, gin.H{"data": todos})
+		listDueTodos(c, func(today time.Time) (*time.Time, *time.Time) {
+			tomorrow := today.AddDate(0, 0, 1)
+			end := today.AddDate(0, 0, days+1)
+			return &tomorrow, &end
+		})
 	}
 }
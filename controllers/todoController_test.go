@@ -0,0 +1,363 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"nitiwat/config"
+	"nitiwat/controllers"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/middleware"
+	"nitiwat/models"
+	"nitiwat/routes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newServer wires the routes the way main does, on memory stores.
+func newServer(t *testing.T) (*gin.Engine, database.Stores) {
+	cfg, err := config.Load([]string{"--store", config.StoreMemory, "--secret-key", "test-secret"})
+	if err != nil {
+		t.Fatalf("loading the configuration: %v", err)
+	}
+	stores := database.NewMemoryStores()
+	controllers.Setup(cfg, stores)
+	helper.Setup(cfg, stores)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	routes.AuthRouter(router)
+	authenticated := router.Group("/", middleware.Authenticate())
+	routes.UserRouter(authenticated)
+	routes.TodoRouter(authenticated)
+	routes.DeletedRouter(authenticated)
+	routes.TagRouter(authenticated)
+	routes.ListRouter(authenticated)
+	routes.AdminRouter(authenticated)
+	return router, stores
+}
+
+// accessToken signs an access token for a user of the given type.
+func accessToken(t *testing.T, userId string, userType string) string {
+	token, _, err := helper.GenerateAllTokens(userId+"@example.com", "Test", "User", userType, userId, helper.NewTokenFamily())
+	if err != nil {
+		t.Fatalf("signing a token: %v", err)
+	}
+	return token
+}
+
+func serve(router *gin.Engine, method string, path string, token string, contentType string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("token", token)
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// otherUsersTodo stores a todo of owner and returns it.
+func otherUsersTodo(t *testing.T, stores database.Stores, owner string) models.Todo {
+	now := time.Now().Truncate(time.Second)
+	todo := models.Todo{
+		ID:          primitive.NewObjectID(),
+		User_id:     owner,
+		Title:       "Private todo",
+		Description: "Only its owner should see it",
+		Created_at:  now,
+		Updated_at:  now,
+	}
+	if err := stores.Todos.Insert(context.Background(), todo); err != nil {
+		t.Fatalf("inserting the todo: %v", err)
+	}
+	return todo
+}
+
+// todoRequest is a request on the todo of another user, with {id} standing
+// for its id.
+type todoRequest struct {
+	name        string
+	method      string
+	path        string
+	contentType string
+	body        string
+}
+
+var todoRequests = []todoRequest{
+	{"get", http.MethodGet, "/todos/{id}", "", ""},
+	{"check", http.MethodPut, "/todos/{id}", "application/json", `{"check": true}`},
+	{"edit", http.MethodPut, "/todos-update/{id}", "application/json", `{"title": "Edited", "description": "Not theirs"}`},
+	{"patch", http.MethodPatch, "/todos/{id}", helper.MergePatchType, `{"title": "Taken over"}`},
+	{"delete", http.MethodDelete, "/todos/{id}", "", ""},
+}
+
+func (r todoRequest) on(todo models.Todo, query string) string {
+	return strings.Replace(r.path, "{id}", todo.ID.Hex(), 1) + query
+}
+
+func TestUserCannotReachAnotherUsersTodo(t *testing.T) {
+	router, stores := newServer(t)
+	owner := primitive.NewObjectID().Hex()
+	todo := otherUsersTodo(t, stores, owner)
+	token := accessToken(t, primitive.NewObjectID().Hex(), "USER")
+
+	for _, r := range todoRequests {
+		t.Run(r.name, func(t *testing.T) {
+			response := serve(router, r.method, r.on(todo, ""), token, r.contentType, r.body)
+			if response.Code != http.StatusNotFound {
+				t.Errorf("%s %s answered %d, want 404: %s", r.method, r.path, response.Code, response.Body)
+			}
+			response = serve(router, r.method, r.on(todo, "?as_user="+owner), token, r.contentType, r.body)
+			if response.Code != http.StatusBadRequest {
+				t.Errorf("%s %s?as_user answered %d, want 400: %s", r.method, r.path, response.Code, response.Body)
+			}
+		})
+	}
+
+	stored, err := stores.Todos.FindById(context.Background(), todo.ID)
+	if err != nil {
+		t.Fatalf("FindById: %v", err)
+	}
+	if stored.Title != todo.Title || stored.Check || stored.Deleted_at != nil {
+		t.Errorf("the todo was changed: %+v", stored)
+	}
+}
+
+func TestUserCannotListAnotherUsersTodos(t *testing.T) {
+	router, stores := newServer(t)
+	owner := primitive.NewObjectID().Hex()
+	otherUsersTodo(t, stores, owner)
+	token := accessToken(t, primitive.NewObjectID().Hex(), "USER")
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{"/todos-user/" + owner, http.StatusNotFound},
+		{"/todos?as_user=" + owner, http.StatusBadRequest},
+		{"/todos?as_user=all", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		response := serve(router, http.MethodGet, c.path, token, "", "")
+		if response.Code != c.want {
+			t.Errorf("GET %s answered %d, want %d: %s", c.path, response.Code, c.want, response.Body)
+		}
+	}
+}
+
+func TestAdminReachesAnotherUsersTodos(t *testing.T) {
+	router, stores := newServer(t)
+	owner := primitive.NewObjectID().Hex()
+	todo := otherUsersTodo(t, stores, owner)
+	token := accessToken(t, primitive.NewObjectID().Hex(), "ADMIN")
+
+	for _, path := range []string{"/todos-user/" + owner, "/todos?as_user=" + owner} {
+		response := serve(router, http.MethodGet, path, token, "", "")
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s answered %d, want 200: %s", path, response.Code, response.Body)
+		}
+		if !strings.Contains(response.Body.String(), todo.ID.Hex()) {
+			t.Errorf("GET %s left out the todo: %s", path, response.Body)
+		}
+	}
+
+	// the requests run in order, so the delete comes last
+	for _, r := range todoRequests {
+		response := serve(router, r.method, r.on(todo, "?as_user="+owner), token, r.contentType, r.body)
+		if response.Code != http.StatusOK {
+			t.Errorf("%s %s?as_user answered %d, want 200: %s", r.method, r.path, response.Code, response.Body)
+		}
+	}
+
+	stored, err := stores.Todos.FindById(context.Background(), todo.ID)
+	if err != nil {
+		t.Fatalf("FindById: %v", err)
+	}
+	if !stored.Check || stored.Description != "Not theirs" || stored.Title != "Taken over" || stored.Deleted_at == nil {
+		t.Errorf("the todo was not checked, edited, patched and trashed: %+v", stored)
+	}
+}
+
+// titlesByPosition returns the titles of the owner's live todos in their
+// order.
+func titlesByPosition(t *testing.T, stores database.Stores, owner string) []string {
+	todos, err := stores.Todos.Find(context.Background(), database.TodoFilter{
+		User_id: owner,
+		Sort:    []database.SortField{{Field: "position"}},
+	})
+	if err != nil {
+		t.Fatalf("finding the todos: %v", err)
+	}
+	titles := make([]string, len(todos))
+	for i, todo := range todos {
+		titles[i] = todo.Title
+	}
+	return titles
+}
+
+func TestMoveTodo(t *testing.T) {
+	router, stores := newServer(t)
+	owner := primitive.NewObjectID().Hex()
+	token := accessToken(t, owner, "USER")
+
+	// the todos have no positions yet, so the first move ranks them first
+	todos := map[string]models.Todo{}
+	for _, title := range []string{"a", "b", "c", "d"} {
+		todo := otherUsersTodo(t, stores, owner)
+		todo.Title = title
+		todo.Version++
+		if err := stores.Todos.Update(context.Background(), todo); err != nil {
+			t.Fatalf("renaming the todo: %v", err)
+		}
+		todos[title] = todo
+	}
+	deleted := otherUsersTodo(t, stores, owner)
+	now := time.Now()
+	deleted.Deleted_at = &now
+	deleted.Version++
+	if err := stores.Todos.Update(context.Background(), deleted); err != nil {
+		t.Fatalf("trashing the todo: %v", err)
+	}
+	id := func(title string) string { return `"` + todos[title].ID.Hex() + `"` }
+
+	cases := []struct {
+		name  string
+		todo  string
+		body  string
+		code  int
+		order []string
+	}{
+		{"to the front", "c", `{"before": ` + id("a") + `}`, http.StatusOK, []string{"c", "a", "b", "d"}},
+		{"to the back", "c", `{"after": ` + id("d") + `}`, http.StatusOK, []string{"a", "b", "d", "c"}},
+		{"between", "c", `{"after": ` + id("a") + `, "before": ` + id("b") + `}`, http.StatusOK, []string{"a", "c", "b", "d"}},
+		{"before itself", "b", `{"before": ` + id("b") + `}`, http.StatusBadRequest, []string{"a", "c", "b", "d"}},
+		{"before a trashed todo", "b", `{"before": "` + deleted.ID.Hex() + `"}`, http.StatusBadRequest, []string{"a", "c", "b", "d"}},
+		{"between apart todos", "d", `{"after": ` + id("a") + `, "before": ` + id("b") + `}`, http.StatusConflict, []string{"a", "c", "b", "d"}},
+		{"no neighbour", "d", `{}`, http.StatusBadRequest, []string{"a", "c", "b", "d"}},
+	}
+	for _, c := range cases {
+		response := serve(router, http.MethodPut, "/todos/"+todos[c.todo].ID.Hex()+"/move", token, "application/json", c.body)
+		if response.Code != c.code {
+			t.Errorf("%s: moving answered %d, want %d: %s", c.name, response.Code, c.code, response.Body)
+		}
+		if order := titlesByPosition(t, stores, owner); strings.Join(order, "") != strings.Join(c.order, "") {
+			t.Errorf("%s: the todos are in the order %v, want %v", c.name, order, c.order)
+		}
+	}
+
+	response := serve(router, http.MethodPut, "/todos/"+deleted.ID.Hex()+"/move", token, "application/json", `{"before": `+id("a")+`}`)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("moving a trashed todo answered %d, want 404: %s", response.Code, response.Body)
+	}
+
+	// ranking the todos again is not recorded in their history
+	for title, moves := range map[string]int{"a": 0, "b": 0, "c": 3, "d": 0} {
+		revisions, err := stores.Revisions.Find(context.Background(), todos[title].ID)
+		if err != nil {
+			t.Fatalf("finding the revisions: %v", err)
+		}
+		if len(revisions) != moves {
+			t.Errorf("%s has %d revisions, want %d", title, len(revisions), moves)
+		}
+	}
+}
+
+func TestPermanentDeleteKeepsHistory(t *testing.T) {
+	router, stores := newServer(t)
+	user := signupUser(t, router, "ann@example.com", "0800000001")
+	owner, token := user.uid, user.token
+
+	for _, path := range []string{"/todos/{id}?permanent=true", "/todos/bulk"} {
+		response := serve(router, http.MethodPost, "/todos", token, "application/json", `{"title": "Buy milk", "description": "Two litres"}`)
+		if response.Code != http.StatusOK {
+			t.Fatalf("POST /todos answered %d: %s", response.Code, response.Body)
+		}
+		var created struct {
+			Data struct {
+				InsertedID primitive.ObjectID
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+			t.Fatalf("decoding the todo: %v", err)
+		}
+		id := created.Data.InsertedID
+
+		if path == "/todos/bulk" {
+			body := `{"operations": [{"op": "delete", "id": "` + id.Hex() + `", "permanent": true}]}`
+			response = serve(router, http.MethodPost, path, token, "application/json", body)
+		} else {
+			response = serve(router, http.MethodDelete, strings.Replace(path, "{id}", id.Hex(), 1), token, "", "")
+		}
+		if response.Code != http.StatusOK {
+			t.Fatalf("deleting through %s answered %d: %s", path, response.Code, response.Body)
+		}
+		if _, err := stores.Todos.FindById(context.Background(), id); err != database.ErrNotFound {
+			t.Errorf("the todo deleted through %s is still there: %v", path, err)
+		}
+
+		revisions, err := stores.Revisions.Find(context.Background(), id)
+		if err != nil {
+			t.Fatalf("finding the revisions: %v", err)
+		}
+		if len(revisions) != 2 || revisions[0].Action != models.RevisionCreate || revisions[1].Action != models.RevisionDelete || revisions[1].Actor != owner {
+			t.Errorf("deleting through %s left the history %+v", path, revisions)
+		}
+	}
+}
+
+func TestCompletedOccurrencesStayShortAndLast(t *testing.T) {
+	router, stores := newServer(t)
+	owner := primitive.NewObjectID().Hex()
+	token := accessToken(t, owner, "USER")
+
+	todo := otherUsersTodo(t, stores, owner)
+	todo.Recurrence = &models.Recurrence{Frequency: models.RepeatDaily, Interval: 1}
+	todo.Position = helper.RankAfter("")
+	todo.Version++
+	if err := stores.Todos.Update(context.Background(), todo); err != nil {
+		t.Fatalf("making the todo recurring: %v", err)
+	}
+	other := otherUsersTodo(t, stores, owner)
+	other.Position = helper.RankAfter(todo.Position)
+	other.Version++
+	if err := stores.Todos.Update(context.Background(), other); err != nil {
+		t.Fatalf("positioning the todo: %v", err)
+	}
+
+	id := todo.ID
+	for i := 0; i < 40; i++ {
+		response := serve(router, http.MethodPut, "/todos/"+id.Hex(), token, "application/json", `{"check": true}`)
+		if response.Code != http.StatusOK {
+			t.Fatalf("completion %d answered %d: %s", i, response.Code, response.Body)
+		}
+		var answer struct {
+			Next models.Todo `json:"next"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &answer); err != nil {
+			t.Fatalf("decoding completion %d: %v", i, err)
+		}
+		id = answer.Next.ID
+	}
+
+	todos, err := stores.Todos.Find(context.Background(), database.TodoFilter{
+		User_id: owner,
+		Sort:    []database.SortField{{Field: "position"}},
+	})
+	if err != nil {
+		t.Fatalf("finding the todos: %v", err)
+	}
+	last := todos[len(todos)-1]
+	if last.ID != id || last.Check {
+		t.Errorf("the open occurrence is not the last todo: %+v", last)
+	}
+	if len(last.Position) > helper.MaxRankLength {
+		t.Errorf("after 40 completions the occurrence is at %q", last.Position)
+	}
+}
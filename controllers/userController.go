@@ -5,25 +5,39 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"nitiwat/config"
 	"nitiwat/database"
 	helper "nitiwat/helpers"
 	"nitiwat/models"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var userCollections *mongo.Collection = database.OpenCollection(database.Client, "users")
-var deleteCollections *mongo.Collection = database.OpenCollection(database.Client, "deleted_users_todo")
+var userStore database.UserStore
+var archiveStore database.ArchiveStore
+
+var cfg *config.Config
 
 var validate = validator.New()
 
+// Setup hands the controllers the configuration and the stores they read and
+// write. It must be called before the routes are served.
+func Setup(config *config.Config, stores database.Stores) {
+	cfg = config
+	todoStore = stores.Todos
+	userStore = stores.Users
+	archiveStore = stores.Archive
+	tagStore = stores.Tags
+	listStore = stores.Lists
+	revisionStore = stores.Revisions
+	transactions = stores.Transactions
+	storage = stores.Storage
+}
+
 func HashPassword(password string) string {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
@@ -47,7 +61,8 @@ func VerifyPassword(userPassWord string, providedPassword string) (bool, string)
 
 func Signup() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
 		var user models.User
 
 		if err := c.BindJSON(&user); err != nil {
@@ -61,8 +76,7 @@ func Signup() gin.HandlerFunc {
 			return
 		}
 
-		countEmail, err := userCollections.CountDocuments(ctx, bson.M{"email": user.Email})
-		defer cancel()
+		countEmail, err := userStore.Count(ctx, database.UserFilter{Email: user.Email})
 
 		if err != nil {
 			log.Panic(err)
@@ -77,8 +91,7 @@ func Signup() gin.HandlerFunc {
 		password := HashPassword(*user.Password)
 		user.Password = &password
 
-		countPhone, err := userCollections.CountDocuments(ctx, bson.M{"phone": user.Phone})
-		defer cancel()
+		countPhone, err := userStore.Count(ctx, database.UserFilter{Phone: user.Phone})
 
 		if err != nil {
 			log.Panic(err)
@@ -94,37 +107,41 @@ func Signup() gin.HandlerFunc {
 		user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_id = user.ID.Hex()
-		token, refreshToken, _ := helper.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id)
+		token, refreshToken, _ := helper.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id, helper.NewTokenFamily())
 		user.Token = &token
 		user.Refresh_token = &refreshToken
-		resultInsertionNumber, insertErr := userCollections.InsertOne(ctx, user)
+		user.Sessions = nil
+		insertErr := userStore.Insert(ctx, user)
 		if insertErr != nil {
 			msg := "User not created"
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 
 		}
-		defer cancel()
+		helper.UpdateAllTokens(token, refreshToken, user.User_id)
 
-		c.JSON(http.StatusOK, gin.H{"data": resultInsertionNumber})
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"InsertedID": user.ID}})
 
 	}
 }
 
 func Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
 
 		var user models.User
-		var foundUser models.User
 
 		if err := c.BindJSON(&user); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
 
-		err := userCollections.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
-		defer cancel()
+		foundUser, err := userStore.FindByEmail(ctx, *user.Email)
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect "})
@@ -132,8 +149,6 @@ func Login() gin.HandlerFunc {
 		}
 		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
 
-		defer cancel()
-
 		if !passwordIsValid {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
@@ -142,11 +157,10 @@ func Login() gin.HandlerFunc {
 		if foundUser.Email == nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Email not found"})
 		}
-		// helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id)
-		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id)
+		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id, helper.NewTokenFamily())
 
 		helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
-		userCollections.FindOne(ctx, bson.M{"user_id": foundUser.User_id}).Decode(&foundUser)
+		foundUser, err = userStore.FindById(ctx, foundUser.User_id)
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -156,99 +170,142 @@ func Login() gin.HandlerFunc {
 	}
 }
 
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		defer cancel()
+
+		var request models.RefreshRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, msg := helper.ValidateRefreshToken(request.Refresh_token)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		foundUser, err := userStore.FindById(ctx, claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "The refresh token is invalid"})
+			return
+		}
+
+		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id, claims.Family)
+		switch err := helper.RotateTokens(token, refreshToken, foundUser.User_id, request.Refresh_token); err {
+		case nil:
+		case helper.ErrSessionRevoked:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		case helper.ErrRefreshTokenReused:
+			// A valid token that is no longer the family's current one has
+			// already been exchanged, so whoever holds it may have stolen it:
+			// end the family.
+			if err := helper.RevokeTokenFamily(foundUser.User_id, claims.Family); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking the session"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "The refresh token was already used, the session has been revoked"})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error storing the tokens"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"token": token, "refresh_token": refreshToken}})
+	}
+}
+
+// revokeCurrentToken revokes the access token the request was authenticated
+// with, in case it is not tracked by any session.
+func revokeCurrentToken(c *gin.Context) error {
+	expiresAt, _ := c.Get("token_expires_at")
+	expires, _ := expiresAt.(time.Time)
+	return helper.RevokeToken(c.GetString("token_id"), expires)
+}
+
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+
+		if err := helper.RevokeTokenFamily(uid, c.GetString("token_family")); err != nil && err != database.ErrNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging out"})
+			return
+		}
+		if err := revokeCurrentToken(c); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	}
+}
+
+func LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+
+		if err := helper.RevokeAllTokens(uid); err != nil && err != database.ErrNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging out"})
+			return
+		}
+		if err := revokeCurrentToken(c); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while logging out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions successfully"})
+	}
+}
+
 func GetUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if err := helper.CheckUserType(c, "ADMIN"); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		recordPerpage, err := strconv.Atoi(c.Query("recordPerpage"))
-
-		if err != nil || recordPerpage < 1 {
-			recordPerpage = 10
-		}
-		page, err1 := strconv.Atoi(c.Query("page"))
-		if err1 != nil || page < 1 {
-			page = 1
-
-		}
-
-		startIndex := (page - 1) * recordPerpage
-
-		startIndex, err = strconv.Atoi(c.Query("startIndex"))
-
-		matchStage := bson.D{primitive.E{
-			Key:   "$match",
-			Value: bson.D{},
-		},
-		}
-		groupStage := bson.D{primitive.E{Key: "$group", Value: bson.D{
-			primitive.E{
-				Key: "_id",
-				Value: bson.D{
-					primitive.E{
-						Key:   "_id",
-						Value: "null",
-					},
-				},
-			},
-			primitive.E{
-				Key: "total_count",
-				Value: bson.D{
-					primitive.E{
-						Key:   "$sum",
-						Value: 1,
-					},
-				},
-			},
-			primitive.E{
-				Key: "data",
-				Value: bson.D{
-					primitive.E{
-						Key:   "$push",
-						Value: "$$ROOT",
-					},
-				},
-			},
-		}}}
-		projectStage := bson.D{
-			primitive.E{
-				Key: "$project",
-				Value: bson.D{
-					primitive.E{
-						Key:   "_id",
-						Value: 0},
-					primitive.E{
-						Key:   "total_count",
-						Value: 1},
-					primitive.E{
-						Key: "user_items",
-						Value: bson.D{
-							primitive.E{
-								Key:   "$slice",
-								Value: []interface{}{"$data", startIndex, recordPerpage},
-							},
-						},
-					},
-				},
-			},
-		}
-		result, err := userCollections.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, projectStage})
-
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
 
+		limit, err := helper.PageLimit(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching users"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var after *primitive.ObjectID
+		if param := c.Query("after"); param != "" {
+			if after, err = database.DecodeIdCursor(param, "users"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "after is not a users cursor"})
+				return
+			}
 		}
 
-		var allUsers []bson.M
-		if err = result.All(ctx, &allUsers); err != nil {
-			log.Fatal(err)
+		total, err := userStore.Count(ctx, database.UserFilter{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching users"})
+			return
+		}
+		users, err := userStore.List(ctx, after, limit+1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching users"})
+			return
+		}
 
+		var next *string
+		if int64(len(users)) > limit {
+			users = users[:limit]
+			cursor := database.EncodeIdCursor("users", users[limit-1].ID)
+			next = &cursor
 		}
-		c.JSON(http.StatusOK, allUsers[0])
+
+		models.RedactUsers(users)
+		c.JSON(http.StatusOK, gin.H{"total_count": total, "user_items": users, "next_cursor": next})
 
 	}
 }
@@ -264,16 +321,19 @@ func GetUser() gin.HandlerFunc {
 		// c.JSON(200, gin.H{
 		// 	"message": "userId",
 		// })
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		var user models.User
-		err := userCollections.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 		defer cancel()
+
+		user, err := userStore.FindById(ctx, userId)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching user"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"data": user})
+		if helper.NotModified(c, user.Version) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": user.Redacted()})
 
 	}
 
@@ -292,54 +352,82 @@ func DeleteUser() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 
 		defer cancel()
 
 		var dataDeleted models.DeleteModal
 
-		var results []models.Todo
 		//find user data
-		userData := userCollections.FindOne(ctx, bson.M{"user_id": userId}).Decode(&dataDeleted.User)
-		if userData != nil {
+		user, errUser := userStore.FindById(ctx, userId)
+		if errUser != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
 			return
 		}
+		if !checkIfMatch(c, user.Version) {
+			return
+		}
+		if err := helper.RevokeUserTokens(ctx, &user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking the user's tokens"})
+			return
+		}
 
 		//find todo data
-		cursor, err := todoCollections.Find(ctx, bson.M{"user_id": userId})
+		results, err := todoStore.Find(ctx, database.TodoFilter{User_id: userId, IncludeTrashed: true})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching todos"})
 			return
 		}
-		if err = cursor.All(ctx, &results); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding todos"})
+		tags, err := tagStore.Find(ctx, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching tags"})
+			return
+		}
+		lists, err := listStore.Find(ctx, userId, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching lists"})
+			return
+		}
+		archivedLists, err := listStore.Find(ctx, userId, true)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching lists"})
 			return
 		}
 
 		//insert data to deleted collection
+		dataDeleted.ID = primitive.NewObjectID()
+		dataDeleted.User = user
 		dataDeleted.Todos = results
-		_, errdelete := deleteCollections.InsertOne(ctx, dataDeleted)
+		dataDeleted.Tags = tags
+		dataDeleted.Lists = append(lists, archivedLists...)
+		dataDeleted.Deleted_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		errdelete := archiveStore.Insert(ctx, dataDeleted)
 		if errdelete != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting user"})
 			return
 		}
 
-		dataDeleted.ID = primitive.NewObjectID()
-
 		// Delete the user
-		_, errDelUser := userCollections.DeleteMany(ctx, bson.M{"user_id": userId})
+		errDelUser := userStore.Delete(ctx, userId)
 		if errDelUser != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting user"})
 			return
 		}
 
 		// Delete the todos associated with the user
-		_, err = todoCollections.DeleteMany(ctx, bson.M{"user_id": userId})
+		err = todoStore.DeleteByUser(ctx, userId)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting user's todos"})
 			return
 		}
+		if err := tagStore.DeleteByUser(ctx, userId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting user's tags"})
+			return
+		}
+		if err := listStore.DeleteByUser(ctx, userId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting user's lists"})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"message": "User and associated todos deleted successfully"})
 	}
@@ -0,0 +1,164 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// signedUp is a user created through the API and logged in once.
+type signedUp struct {
+	uid           string
+	token         string
+	refresh_token string
+}
+
+// signupUser signs a USER up and logs them in.
+func signupUser(t *testing.T, router *gin.Engine, email string, phone string) signedUp {
+	body := `{"first_name": "Ann", "last_name": "Lee", "Password": "secret123", "email": "` + email + `", "phone": "` + phone + `", "user_type": "USER"}`
+	response := serve(router, http.MethodPost, "/users/signup", "", "application/json", body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("signing %s up answered %d: %s", email, response.Code, response.Body)
+	}
+	return loginUser(t, router, email)
+}
+
+// loginUser logs a user signed up by signupUser in, which starts a session.
+func loginUser(t *testing.T, router *gin.Engine, email string) signedUp {
+	body := `{"email": "` + email + `", "Password": "secret123"}`
+	response := serve(router, http.MethodPost, "/users/login", "", "application/json", body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("logging %s in answered %d: %s", email, response.Code, response.Body)
+	}
+	var login struct {
+		Data struct {
+			User_id       string `json:"user_id"`
+			Token         string `json:"token"`
+			Refresh_token string `json:"refresh_token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &login); err != nil {
+		t.Fatalf("decoding the login of %s: %v", email, err)
+	}
+	return signedUp{login.Data.User_id, login.Data.Token, login.Data.Refresh_token}
+}
+
+func TestUserListsHidePasswordsAndTokens(t *testing.T) {
+	router, _ := newServer(t)
+	user := signupUser(t, router, "ann@example.com", "0800000001")
+	token := accessToken(t, primitive.NewObjectID().Hex(), "ADMIN")
+
+	for _, path := range []string{"/users", "/users/" + user.uid} {
+		response := serve(router, http.MethodGet, path, token, "", "")
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s answered %d: %s", path, response.Code, response.Body)
+		}
+		for _, secret := range []string{user.token, user.refresh_token, "$2a$"} {
+			if strings.Contains(response.Body.String(), secret) {
+				t.Errorf("GET %s shows %q: %s", path, secret, response.Body)
+			}
+		}
+	}
+}
+
+func TestDeletedUserTokensStopWorking(t *testing.T) {
+	router, stores := newServer(t)
+	user := signupUser(t, router, "ann@example.com", "0800000001")
+	token := accessToken(t, primitive.NewObjectID().Hex(), "ADMIN")
+
+	response := serve(router, http.MethodDelete, "/users/"+user.uid, token, "", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("DELETE /users/%s answered %d: %s", user.uid, response.Code, response.Body)
+	}
+
+	response = serve(router, http.MethodGet, "/todos", user.token, "", "")
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("GET /todos with the deleted user's token answered %d, want 401: %s", response.Code, response.Body)
+	}
+	response = serve(router, http.MethodPost, "/users/refresh", "", "application/json", `{"refresh_token": "`+user.refresh_token+`"}`)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("refreshing the deleted user's token answered %d, want 401: %s", response.Code, response.Body)
+	}
+
+	archives, err := stores.Archive.Find(context.Background(), nil, 10)
+	if err != nil {
+		t.Fatalf("finding the archive: %v", err)
+	}
+	if len(archives) != 1 || len(archives[0].User.Sessions) != 0 || archives[0].User.Refresh_token != nil {
+		t.Errorf("the archived user kept their sessions: %+v", archives)
+	}
+}
+
+// refresh exchanges a refresh token and returns the response with the new
+// pair, which is empty when it was refused.
+func refresh(router *gin.Engine, refreshToken string) (code int, pair signedUp) {
+	response := serve(router, http.MethodPost, "/users/refresh", "", "application/json", `{"refresh_token": "`+refreshToken+`"}`)
+	var answer struct {
+		Data struct {
+			Token         string `json:"token"`
+			Refresh_token string `json:"refresh_token"`
+		} `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &answer)
+	return response.Code, signedUp{token: answer.Data.Token, refresh_token: answer.Data.Refresh_token}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	router, _ := newServer(t)
+	user := signupUser(t, router, "ann@example.com", "0800000001")
+	other := signupUser(t, router, "bob@example.com", "0800000002")
+	// a second session of the same user is a family of its own
+	elsewhere := loginUser(t, router, "ann@example.com")
+
+	code, first := refresh(router, user.refresh_token)
+	if code != http.StatusOK || first.token == "" || first.refresh_token == user.refresh_token {
+		t.Fatalf("the first refresh answered %d with %+v", code, first)
+	}
+	code, second := refresh(router, first.refresh_token)
+	if code != http.StatusOK || second.refresh_token == first.refresh_token {
+		t.Fatalf("the second refresh answered %d with %+v", code, second)
+	}
+
+	cases := []struct {
+		name    string
+		refresh string
+		code    int
+	}{
+		{"garbage", "not-a-token", http.StatusUnauthorized},
+		{"an access token", second.token, http.StatusUnauthorized},
+		// replaying a used token ends the family, current token included
+		{"a replayed token", user.refresh_token, http.StatusUnauthorized},
+		{"the current token of the revoked family", second.refresh_token, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if code, _ := refresh(router, c.refresh); code != c.code {
+			t.Errorf("refreshing %s answered %d, want %d", c.name, code, c.code)
+		}
+	}
+
+	for _, access := range []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"the login token of the revoked family", user.token, http.StatusUnauthorized},
+		{"a rotated token of the revoked family", first.token, http.StatusUnauthorized},
+		{"the latest token of the revoked family", second.token, http.StatusUnauthorized},
+		{"another session's token", elsewhere.token, http.StatusOK},
+		{"another user's token", other.token, http.StatusOK},
+	} {
+		if response := serve(router, http.MethodGet, "/todos", access.token, "", ""); response.Code != access.code {
+			t.Errorf("GET /todos with %s answered %d, want %d: %s", access.name, response.Code, access.code, response.Body)
+		}
+	}
+	for _, token := range []string{elsewhere.refresh_token, other.refresh_token} {
+		if code, _ := refresh(router, token); code != http.StatusOK {
+			t.Errorf("refreshing outside the revoked family answered %d, want 200", code)
+		}
+	}
+}
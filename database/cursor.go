@@ -0,0 +1,99 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"nitiwat/models"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded or was issued
+// for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the decoded form of the opaque after= value: the sort order it was
+// issued for and the sort fields and id of the last document of the page.
+type cursor struct {
+	Sort     string          `json:"sort"`
+	Position json.RawMessage `json:"position"`
+}
+
+func encodeCursor(sort string, position interface{}) string {
+	raw, _ := json.Marshal(position)
+	data, _ := json.Marshal(cursor{Sort: sort, Position: raw})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string, sort string, position interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(c.Position, position); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// SortString renders sort fields the way the sort query parameter spells them.
+func SortString(fields []SortField) string {
+	names := []string{}
+	for _, field := range fields {
+		if field.Desc {
+			names = append(names, "-"+field.Field)
+		} else {
+			names = append(names, field.Field)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// EncodeTodoCursor returns the cursor of the page that follows todo in the
+// given sort order.
+func EncodeTodoCursor(sort []SortField, todo models.Todo) string {
+	data, _ := json.Marshal(todo)
+	all := map[string]json.RawMessage{}
+	json.Unmarshal(data, &all)
+
+	position := map[string]json.RawMessage{"id": all["id"]}
+	for _, field := range sort {
+		position[field.Field] = all[field.Field]
+	}
+	return encodeCursor("todos:"+SortString(sort), position)
+}
+
+// DecodeTodoCursor returns a todo carrying the sort fields and id encoded in
+// the cursor, for use as TodoFilter.After.
+func DecodeTodoCursor(value string, sort []SortField) (*models.Todo, error) {
+	var todo models.Todo
+	if err := decodeCursor(value, "todos:"+SortString(sort), &todo); err != nil {
+		return nil, err
+	}
+	return &todo, nil
+}
+
+type idPosition struct {
+	ID primitive.ObjectID `json:"id"`
+}
+
+// EncodeIdCursor returns the cursor of the page that follows the document
+// with the given id in a collection ordered by id.
+func EncodeIdCursor(collection string, id primitive.ObjectID) string {
+	return encodeCursor(collection+":id", idPosition{ID: id})
+}
+
+// DecodeIdCursor returns the id encoded by EncodeIdCursor.
+func DecodeIdCursor(value string, collection string) (*primitive.ObjectID, error) {
+	var position idPosition
+	if err := decodeCursor(value, collection+":id", &position); err != nil {
+		return nil, err
+	}
+	return &position.ID, nil
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"encoding/base64"
+	"nitiwat/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTodoCursorRoundTrip(t *testing.T) {
+	due := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	todo := models.Todo{
+		ID:         primitive.NewObjectID(),
+		User_id:    "u1",
+		Title:      "Buy milk",
+		Priority:   models.PriorityHigh,
+		Due_at:     &due,
+		Created_at: due.Add(-time.Hour),
+		Position:   "i",
+	}
+
+	cases := []struct {
+		name  string
+		sort  []SortField
+		check func(after *models.Todo) bool
+	}{
+		{"position", []SortField{{Field: "position"}}, func(after *models.Todo) bool { return after.Position == todo.Position }},
+		{"priority descending", []SortField{{Field: "priority", Desc: true}}, func(after *models.Todo) bool { return after.Priority == todo.Priority }},
+		{"due date then title", []SortField{{Field: "due_at"}, {Field: "title"}}, func(after *models.Todo) bool {
+			return after.Due_at != nil && after.Due_at.Equal(due) && after.Title == todo.Title
+		}},
+		{"missing due date", []SortField{{Field: "due_at"}}, func(after *models.Todo) bool { return after.Due_at == nil }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			encoded := todo
+			if c.name == "missing due date" {
+				encoded.Due_at = nil
+			}
+			after, err := DecodeTodoCursor(EncodeTodoCursor(c.sort, encoded), c.sort)
+			if err != nil {
+				t.Fatalf("DecodeTodoCursor: %v", err)
+			}
+			if after.ID != todo.ID || !c.check(after) {
+				t.Errorf("the cursor decoded to %+v", after)
+			}
+			// only the sort fields and the id are carried
+			if after.User_id != "" || after.Description != "" {
+				t.Errorf("the cursor carries more than its position: %+v", after)
+			}
+		})
+	}
+}
+
+func TestCursorRejectsTampering(t *testing.T) {
+	sort := []SortField{{Field: "priority", Desc: true}}
+	valid := EncodeTodoCursor(sort, models.Todo{ID: primitive.NewObjectID(), Priority: models.PriorityLow})
+	data, _ := base64.RawURLEncoding.DecodeString(valid)
+	flipped := append([]byte{}, data...)
+	flipped[0] ^= 0xff
+
+	cases := []struct {
+		name   string
+		cursor string
+		sort   []SortField
+	}{
+		{"empty", "", sort},
+		{"not base64", "not a cursor!", sort},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("todos")), sort},
+		{"flipped byte", base64.RawURLEncoding.EncodeToString(flipped), sort},
+		{"truncated", valid[:len(valid)/2], sort},
+		{"other sort order", valid, []SortField{{Field: "priority"}}},
+		{"other sort field", valid, []SortField{{Field: "title", Desc: true}}},
+		{"user cursor", EncodeIdCursor("users", primitive.NewObjectID()), sort},
+		{"wrong field type", encodeCursor("todos:-priority", map[string]string{"priority": "high"}), sort},
+		{"bad id", encodeCursor("todos:-priority", map[string]string{"id": "not-an-id"}), sort},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if after, err := DecodeTodoCursor(c.cursor, c.sort); err != ErrInvalidCursor {
+				t.Errorf("DecodeTodoCursor = %+v, %v, want ErrInvalidCursor", after, err)
+			}
+		})
+	}
+}
+
+func TestIdCursor(t *testing.T) {
+	id := primitive.NewObjectID()
+	cursor := EncodeIdCursor("users", id)
+
+	decoded, err := DecodeIdCursor(cursor, "users")
+	if err != nil || *decoded != id {
+		t.Fatalf("DecodeIdCursor = %v, %v, want %s", decoded, err, id.Hex())
+	}
+	for _, collection := range []string{"deleted", "todos"} {
+		if _, err := DecodeIdCursor(cursor, collection); err != ErrInvalidCursor {
+			t.Errorf("a users cursor decoded as a %s cursor: %v", collection, err)
+		}
+	}
+}
@@ -2,26 +2,20 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"nitiwat/config"
 	"time"
 
-	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func DBinstance() *mongo.Client {
-	err := godotenv.Load(".env")
+func DBinstance(cfg *config.Config) (*mongo.Client, error) {
+	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.MongoURL))
 	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
-	MongoDb := os.Getenv("MONGO_DB_URL")
-	client, err := mongo.NewClient(options.Client().ApplyURI(MongoDb))
-	if err != nil {
-		log.Fatalf("Error connecting to databases")
+		return nil, fmt.Errorf("error connecting to databases: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -30,17 +24,63 @@ func DBinstance() *mongo.Client {
 
 	err = client.Connect(ctx)
 	if err != nil {
-		log.Fatalf("Error connecting to database")
+		return nil, fmt.Errorf("error connecting to database: %v", err)
 	}
 
 	fmt.Println("Connected to MongoDB!")
 
-	return client
+	return client, nil
 }
 
-var Client *mongo.Client = DBinstance()
-
-func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	var collection *mongo.Collection = client.Database("cluster0").Collection(collectionName)
+func OpenCollection(client *mongo.Client, databaseName string, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database(databaseName).Collection(collectionName)
 	return collection
 }
+
+// ErrNoTransactions is returned when the Mongo server cannot run the
+// transactions the stores make their writes in, as a standalone server cannot.
+var ErrNoTransactions = errors.New("MongoDB must be a replica set or a sharded cluster, since writes run in transactions; a single server can be started as a one-member replica set with --replSet")
+
+// CheckTransactions fails with ErrNoTransactions unless the Mongo deployment
+// the client is connected to supports transactions.
+func CheckTransactions(ctx context.Context, client *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return err
+	}
+	// a mongos answers isdbgrid
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return ErrNoTransactions
+	}
+	return nil
+}
+
+// NewStores returns the stores for the backend selected in cfg, connecting to
+// Mongo when needed.
+func NewStores(cfg *config.Config) (Stores, error) {
+	if cfg.Store == config.StoreMemory {
+		return NewMemoryStores(), nil
+	}
+
+	client, err := DBinstance(cfg)
+	if err != nil {
+		return Stores{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := CheckTransactions(ctx, client); err != nil {
+		return Stores{}, fmt.Errorf("error checking the database: %v", err)
+	}
+	if err := CreateIndexes(ctx, client, cfg.Database); err != nil {
+		return Stores{}, fmt.Errorf("error creating indexes: %v", err)
+	}
+	if err := MigrateTodos(ctx, client, cfg.Database); err != nil {
+		return Stores{}, fmt.Errorf("error migrating todos: %v", err)
+	}
+	return NewMongoStores(client, cfg.Database), nil
+}
@@ -0,0 +1,1144 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"nitiwat/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMemoryStores returns stores that keep everything in process memory. They
+// behave like the Mongo stores and are meant for local development and tests.
+func NewMemoryStores() Stores {
+	todos := &memoryTodoStore{todos: map[primitive.ObjectID]models.Todo{}, text: newTextIndex()}
+	users := &memoryUserStore{users: map[string]models.User{}}
+	archive := &memoryArchiveStore{archives: map[primitive.ObjectID]models.DeleteModal{}}
+	revocations := &memoryRevocationStore{tokens: map[string]time.Time{}}
+	tags := &memoryTagStore{tags: map[primitive.ObjectID]models.Tag{}}
+	lists := &memoryListStore{lists: map[primitive.ObjectID]models.List{}}
+	revisions := &memoryRevisionStore{revisions: map[primitive.ObjectID]models.Revision{}}
+	idempotency := &memoryIdempotencyStore{records: map[string]models.IdempotencyRecord{}}
+	return Stores{
+		Todos:        todos,
+		Users:        users,
+		Archive:      archive,
+		Revocations:  revocations,
+		Tags:         tags,
+		Lists:        lists,
+		Revisions:    revisions,
+		Idempotency:  idempotency,
+		Transactions: memoryTransactor{},
+		Storage: memoryStorage{
+			func() CollectionSize { return memorySize("todos", &todos.mu, todos.todos) },
+			func() CollectionSize { return memorySize("users", &users.mu, users.users) },
+			func() CollectionSize { return memorySize("deleted_users_todo", &archive.mu, archive.archives) },
+			func() CollectionSize { return memorySize("revoked_tokens", &revocations.mu, revocations.tokens) },
+			func() CollectionSize { return memorySize("tags", &tags.mu, tags.tags) },
+			func() CollectionSize { return memorySize("lists", &lists.mu, lists.lists) },
+			func() CollectionSize { return memorySize("todo_revisions", &revisions.mu, revisions.revisions) },
+			func() CollectionSize { return memorySize("idempotency_keys", &idempotency.mu, idempotency.records) },
+		},
+	}
+}
+
+// memoryStorage measures the maps of the memory stores, one function per map
+// named after the Mongo collection it stands in for.
+type memoryStorage []func() CollectionSize
+
+func (s memoryStorage) CollectionSizes(ctx context.Context) ([]CollectionSize, error) {
+	sizes := []CollectionSize{}
+	for _, size := range s {
+		sizes = append(sizes, size())
+	}
+	return sizes, nil
+}
+
+// memorySize counts the documents of a map and the bytes they would take up
+// as BSON. Nothing is indexed or allocated ahead, so the storage size is the
+// data size.
+func memorySize[K comparable, V any](name string, mu *sync.RWMutex, m map[K]V) CollectionSize {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	size := CollectionSize{Name: name, Documents: int64(len(m))}
+	for key, value := range m {
+		data, err := bson.Marshal(bson.M{"_id": key, "value": value})
+		if err == nil {
+			size.Data_bytes += int64(len(data))
+		}
+	}
+	size.Storage_bytes = size.Data_bytes
+	return size
+}
+
+// memoryTransaction collects the functions undoing the changes made within a
+// transaction, in the order the changes were made.
+type memoryTransaction struct {
+	mu   sync.Mutex
+	undo []func()
+}
+
+type memoryTransactionKey struct{}
+
+// memoryTransactor rolls a failed transaction back by undoing its changes.
+// Unlike a Mongo transaction it does not hide them from other requests until
+// it commits.
+type memoryTransactor struct{}
+
+func (memoryTransactor) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction); ok {
+		return fn(ctx)
+	}
+	tx := &memoryTransaction{}
+	err := fn(context.WithValue(ctx, memoryTransactionKey{}, tx))
+	if err != nil {
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+	}
+	return err
+}
+
+// journal records how to restore the entry of m under key to what it is now,
+// when ctx belongs to a transaction. It is called with mu held, before the
+// entry changes. restored, when not nil, is called with mu held after the
+// entry is restored.
+func journal[K comparable, V any](ctx context.Context, mu *sync.RWMutex, m map[K]V, key K, restored func(key K)) {
+	tx, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction)
+	if !ok {
+		return
+	}
+	prior, existed := m[key]
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.undo = append(tx.undo, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if existed {
+			m[key] = prior
+		} else {
+			delete(m, key)
+		}
+		if restored != nil {
+			restored(key)
+		}
+	})
+}
+
+// lessObjectID orders ids by creation time, which matches Mongo's natural
+// order for documents inserted by this server.
+func lessObjectID(a primitive.ObjectID, b primitive.ObjectID) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+type memoryTodoStore struct {
+	mu    sync.RWMutex
+	todos map[primitive.ObjectID]models.Todo
+	text  *textIndex
+}
+
+// textIndex is an inverted index of the words of the titles and descriptions
+// of todos, standing in for the text index of the Mongo store.
+type textIndex struct {
+	postings map[string]map[primitive.ObjectID]bool
+	words    map[primitive.ObjectID][]string
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{
+		postings: map[string]map[primitive.ObjectID]bool{},
+		words:    map[primitive.ObjectID][]string{},
+	}
+}
+
+func (x *textIndex) add(todo models.Todo) {
+	x.remove(todo.ID)
+	words := []string{}
+	for _, word := range tokenTexts(todo.Title + " " + todo.Description) {
+		if x.postings[word] == nil {
+			x.postings[word] = map[primitive.ObjectID]bool{}
+		}
+		if !x.postings[word][todo.ID] {
+			x.postings[word][todo.ID] = true
+			words = append(words, word)
+		}
+	}
+	x.words[todo.ID] = words
+}
+
+func (x *textIndex) remove(id primitive.ObjectID) {
+	for _, word := range x.words[id] {
+		delete(x.postings[word], id)
+		if len(x.postings[word]) == 0 {
+			delete(x.postings, word)
+		}
+	}
+	delete(x.words, id)
+}
+
+// candidates returns the ids of the todos holding every word of the query,
+// a word starting with each prefix and every word of each phrase. Whether
+// the phrases appear as such is left to scoreTodo.
+func (x *textIndex) candidates(query SearchQuery) map[primitive.ObjectID]bool {
+	var ids map[primitive.ObjectID]bool
+	narrow := func(matches map[primitive.ObjectID]bool) {
+		if ids == nil {
+			ids = matches
+			return
+		}
+		kept := map[primitive.ObjectID]bool{}
+		for id := range ids {
+			if matches[id] {
+				kept[id] = true
+			}
+		}
+		ids = kept
+	}
+
+	for _, word := range query.Words {
+		narrow(x.postings[word])
+	}
+	for _, phrase := range query.Phrases {
+		for _, word := range phrase {
+			narrow(x.postings[word])
+		}
+	}
+	for _, prefix := range query.Prefixes {
+		matches := map[primitive.ObjectID]bool{}
+		for word, postings := range x.postings {
+			if strings.HasPrefix(word, prefix) {
+				for id := range postings {
+					matches[id] = true
+				}
+			}
+		}
+		narrow(matches)
+	}
+	return ids
+}
+
+// reindex brings the text index up to date with the todo stored under id. It
+// is called with s.mu held.
+func (s *memoryTodoStore) reindex(id primitive.ObjectID) {
+	if todo, ok := s.todos[id]; ok {
+		s.text.add(todo)
+		return
+	}
+	s.text.remove(id)
+}
+
+func (s *memoryTodoStore) Search(ctx context.Context, userId string, query SearchQuery, limit int64) ([]SearchHit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	todos := []models.Todo{}
+	for id := range s.text.candidates(query) {
+		todo := s.todos[id]
+		if todo.Deleted_at == nil && (userId == "" || todo.User_id == userId) {
+			todos = append(todos, cloneTodo(todo))
+		}
+	}
+	return rankHits(todos, query, limit), nil
+}
+
+func matchTodo(todo models.Todo, filter TodoFilter) bool {
+	if filter.User_id != "" && todo.User_id != filter.User_id {
+		return false
+	}
+	if filter.Check != nil && todo.Check != *filter.Check {
+		return false
+	}
+	if len(filter.Priorities) > 0 && !containsInt(filter.Priorities, todo.Priority) {
+		return false
+	}
+	if len(filter.Tags) > 0 && !matchTags(todo.Tags, filter.Tags, filter.AllTags) {
+		return false
+	}
+	if filter.List_id != nil && (todo.List_id == nil || *todo.List_id != *filter.List_id) {
+		return false
+	}
+	if filter.Series_id != nil && (todo.Series_id == nil || *todo.Series_id != *filter.Series_id) {
+		return false
+	}
+	if filter.Text != "" {
+		text := strings.ToLower(filter.Text)
+		if !strings.Contains(strings.ToLower(todo.Title), text) && !strings.Contains(strings.ToLower(todo.Description), text) {
+			return false
+		}
+	}
+	if !inTimeRange(todo.Created_at, filter.Created_after, filter.Created_before) {
+		return false
+	}
+	if !inTimeRange(todo.Updated_at, filter.Updated_after, filter.Updated_before) {
+		return false
+	}
+	if !filter.IncludeTrashed && filter.Deleted_before == nil && filter.Trashed != (todo.Deleted_at != nil) {
+		return false
+	}
+	if filter.Deleted_before != nil && (todo.Deleted_at == nil || !todo.Deleted_at.Before(*filter.Deleted_before)) {
+		return false
+	}
+	if filter.Due_after != nil && (todo.Due_at == nil || todo.Due_at.Before(*filter.Due_after)) {
+		return false
+	}
+	if filter.Due_before != nil && (todo.Due_at == nil || !todo.Due_at.Before(*filter.Due_before)) {
+		return false
+	}
+	return true
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTags reports whether tags holds any of wanted, or all of them.
+func matchTags(tags []string, wanted []string, all bool) bool {
+	for _, tag := range wanted {
+		if containsString(tags, tag) != all {
+			return !all
+		}
+	}
+	return all
+}
+
+// cloneTodo copies the slices of a todo so callers never share them with the
+// stored document.
+func cloneTodo(todo models.Todo) models.Todo {
+	if todo.Tags != nil {
+		todo.Tags = append([]string{}, todo.Tags...)
+	}
+	if todo.Items != nil {
+		todo.Items = append([]models.ChecklistItem{}, todo.Items...)
+	}
+	return todo
+}
+
+func inTimeRange(t time.Time, after *time.Time, before *time.Time) bool {
+	if after != nil && t.Before(*after) {
+		return false
+	}
+	if before != nil && !t.Before(*before) {
+		return false
+	}
+	return true
+}
+
+func compareBools(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+func compareTimes(a *time.Time, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+// compareTodoField orders two todos by one sortable field the way Mongo does.
+func compareTodoField(a models.Todo, b models.Todo, field string) int {
+	switch field {
+	case "due_at":
+		return compareTimes(a.Due_at, b.Due_at)
+	case "created_at":
+		return a.Created_at.Compare(b.Created_at)
+	case "updated_at":
+		return a.Updated_at.Compare(b.Updated_at)
+	case "priority":
+		return a.Priority - b.Priority
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "position":
+		return strings.Compare(a.Position, b.Position)
+	case "check":
+		return compareBools(a.Check, b.Check)
+	}
+	return 0
+}
+
+// compareTodos orders two todos by the sort fields, then by id.
+func compareTodos(a models.Todo, b models.Todo, fields []SortField) int {
+	for _, field := range fields {
+		cmp := compareTodoField(a, b, field.Field)
+		if field.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+func sortTodos(todos []models.Todo, fields []SortField) {
+	sort.Slice(todos, func(i, j int) bool { return compareTodos(todos[i], todos[j], fields) < 0 })
+}
+
+func (s *memoryTodoStore) Find(ctx context.Context, filter TodoFilter) ([]models.Todo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	todos := []models.Todo{}
+	for _, todo := range s.todos {
+		if !matchTodo(todo, filter) {
+			continue
+		}
+		if filter.After != nil && compareTodos(todo, *filter.After, filter.Sort) <= 0 {
+			continue
+		}
+		todos = append(todos, cloneTodo(todo))
+	}
+	sortTodos(todos, filter.Sort)
+	if filter.Limit > 0 && int64(len(todos)) > filter.Limit {
+		todos = todos[:filter.Limit]
+	}
+	return todos, nil
+}
+
+// Each works on a copy of the matched todos, so fn may take its time without
+// holding up writers.
+func (s *memoryTodoStore) Each(ctx context.Context, filter TodoFilter, fn func(todo models.Todo) error) error {
+	todos, err := s.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryTodoStore) Count(ctx context.Context, filter TodoFilter) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, todo := range s.todos {
+		if matchTodo(todo, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryTodoStore) FindById(ctx context.Context, id primitive.ObjectID) (models.Todo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	todo, ok := s.todos[id]
+	if !ok {
+		return models.Todo{}, ErrNotFound
+	}
+	return cloneTodo(todo), nil
+}
+
+func (s *memoryTodoStore) FindByTitle(ctx context.Context, userId string, title string) (models.Todo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, todo := range s.todos {
+		if todo.User_id == userId && todo.Title == title && todo.Deleted_at == nil {
+			return cloneTodo(todo), nil
+		}
+	}
+	return models.Todo{}, ErrNotFound
+}
+
+func (s *memoryTodoStore) Insert(ctx context.Context, todo models.Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	journal(ctx, &s.mu, s.todos, todo.ID, s.reindex)
+	s.todos[todo.ID] = cloneTodo(todo)
+	s.reindex(todo.ID)
+	return nil
+}
+
+func (s *memoryTodoStore) Update(ctx context.Context, todo models.Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.todos[todo.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != todo.Version-1 {
+		return ErrVersionConflict
+	}
+	journal(ctx, &s.mu, s.todos, todo.ID, s.reindex)
+	s.todos[todo.ID] = cloneTodo(todo)
+	s.reindex(todo.ID)
+	return nil
+}
+
+func (s *memoryTodoStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.todos[id]; !ok {
+		return ErrNotFound
+	}
+	journal(ctx, &s.mu, s.todos, id, s.reindex)
+	delete(s.todos, id)
+	s.reindex(id)
+	return nil
+}
+
+func (s *memoryTodoStore) DeleteByUser(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, todo := range s.todos {
+		if todo.User_id == userId {
+			journal(ctx, &s.mu, s.todos, id, s.reindex)
+			delete(s.todos, id)
+			s.reindex(id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryTodoStore) DeleteMany(ctx context.Context, filter TodoFilter) ([]primitive.ObjectID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []primitive.ObjectID{}
+	for id, todo := range s.todos {
+		if matchTodo(todo, filter) {
+			journal(ctx, &s.mu, s.todos, id, s.reindex)
+			delete(s.todos, id)
+			s.reindex(id)
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memoryTodoStore) CountByList(ctx context.Context, userId string) (map[primitive.ObjectID]ListCounts, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[primitive.ObjectID]ListCounts{}
+	for _, todo := range s.todos {
+		if todo.List_id == nil || todo.Deleted_at != nil || (userId != "" && todo.User_id != userId) {
+			continue
+		}
+		count := counts[*todo.List_id]
+		if todo.Check {
+			count.Done++
+		} else {
+			count.Open++
+		}
+		counts[*todo.List_id] = count
+	}
+	return counts, nil
+}
+
+func (s *memoryTodoStore) CountByUser(ctx context.Context) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, todo := range s.todos {
+		if todo.Deleted_at == nil {
+			counts[todo.User_id]++
+		}
+	}
+	return counts, nil
+}
+
+type memoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]models.User
+}
+
+// cloneUser copies the slices of a user so callers never share them with the
+// stored document.
+func cloneUser(user models.User) models.User {
+	user.Sessions = append([]models.Session(nil), user.Sessions...)
+	for i := range user.Sessions {
+		user.Sessions[i].Access_tokens = append([]models.IssuedToken(nil), user.Sessions[i].Access_tokens...)
+	}
+	return user
+}
+
+func (s *memoryUserStore) FindById(ctx context.Context, userId string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (s *memoryUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email != nil && *user.Email == email {
+			return cloneUser(user), nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (s *memoryUserStore) Count(ctx context.Context, filter UserFilter) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, user := range s.users {
+		if filter.Email != nil && (user.Email == nil || *user.Email != *filter.Email) {
+			continue
+		}
+		if filter.Phone != nil && (user.Phone == nil || *user.Phone != *filter.Phone) {
+			continue
+		}
+		if filter.User_type != nil && (user.User_type == nil || *user.User_type != *filter.User_type) {
+			continue
+		}
+		if filter.Updated_after != nil && user.Updated_at.Before(*filter.Updated_after) {
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s *memoryUserStore) CountCreatedByDay(ctx context.Context, since time.Time, loc *time.Location) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, user := range s.users {
+		if !user.Created_at.Before(since) {
+			counts[user.Created_at.In(loc).Format("2006-01-02")]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *memoryUserStore) List(ctx context.Context, after *primitive.ObjectID, limit int64) ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := []models.User{}
+	for _, user := range s.users {
+		if after == nil || lessObjectID(*after, user.ID) {
+			users = append(users, cloneUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return lessObjectID(users[i].ID, users[j].ID) })
+	if limit > 0 && int64(len(users)) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *memoryUserStore) Insert(ctx context.Context, user models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	journal(ctx, &s.mu, s.users, user.User_id, nil)
+	s.users[user.User_id] = cloneUser(user)
+	return nil
+}
+
+func (s *memoryUserStore) Update(ctx context.Context, user models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.User_id]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != user.Version-1 {
+		return ErrVersionConflict
+	}
+	journal(ctx, &s.mu, s.users, user.User_id, nil)
+	s.users[user.User_id] = cloneUser(user)
+	return nil
+}
+
+func (s *memoryUserStore) Delete(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	journal(ctx, &s.mu, s.users, userId, nil)
+	delete(s.users, userId)
+	return nil
+}
+
+type memoryArchiveStore struct {
+	mu       sync.RWMutex
+	archives map[primitive.ObjectID]models.DeleteModal
+}
+
+func (s *memoryArchiveStore) Find(ctx context.Context, after *primitive.ObjectID, limit int64) ([]models.DeleteModal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	archives := []models.DeleteModal{}
+	for _, archive := range s.archives {
+		if after == nil || lessObjectID(*after, archive.ID) {
+			archives = append(archives, archive)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return lessObjectID(archives[i].ID, archives[j].ID) })
+	if limit > 0 && int64(len(archives)) > limit {
+		archives = archives[:limit]
+	}
+	return archives, nil
+}
+
+// archiveDeletedBefore leaves out archives without a deletion time, like the
+// Mongo store does for documents written before it was recorded.
+func archiveDeletedBefore(archive models.DeleteModal, cutoff time.Time) bool {
+	return !archive.Deleted_at.IsZero() && archive.Deleted_at.Before(cutoff)
+}
+
+func (s *memoryArchiveStore) FindDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.DeleteModal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	archives := []models.DeleteModal{}
+	for _, archive := range s.archives {
+		if archiveDeletedBefore(archive, cutoff) {
+			archives = append(archives, archive)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return lessObjectID(archives[i].ID, archives[j].ID) })
+	return archives, nil
+}
+
+func (s *memoryArchiveStore) Count(ctx context.Context, deletedBefore *time.Time) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, archive := range s.archives {
+		if deletedBefore == nil || archiveDeletedBefore(archive, *deletedBefore) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryArchiveStore) FindById(ctx context.Context, id primitive.ObjectID) (models.DeleteModal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	archive, ok := s.archives[id]
+	if !ok {
+		return models.DeleteModal{}, ErrNotFound
+	}
+	return archive, nil
+}
+
+func (s *memoryArchiveStore) Insert(ctx context.Context, archive models.DeleteModal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	journal(ctx, &s.mu, s.archives, archive.ID, nil)
+	s.archives[archive.ID] = archive
+	return nil
+}
+
+func (s *memoryArchiveStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.archives[id]; !ok {
+		return ErrNotFound
+	}
+	journal(ctx, &s.mu, s.archives, id, nil)
+	delete(s.archives, id)
+	return nil
+}
+
+func (s *memoryArchiveStore) DeleteDeletedBefore(ctx context.Context, cutoff time.Time) (archives int64, todoIds []primitive.ObjectID, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todoIds = []primitive.ObjectID{}
+	for id, archive := range s.archives {
+		if archiveDeletedBefore(archive, cutoff) {
+			journal(ctx, &s.mu, s.archives, id, nil)
+			delete(s.archives, id)
+			archives++
+			for _, todo := range archive.Todos {
+				todoIds = append(todoIds, todo.ID)
+			}
+		}
+	}
+	return archives, todoIds, nil
+}
+
+type memoryTagStore struct {
+	mu   sync.RWMutex
+	tags map[primitive.ObjectID]models.Tag
+}
+
+func (s *memoryTagStore) Find(ctx context.Context, userId string) ([]models.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tags := []models.Tag{}
+	for _, tag := range s.tags {
+		if userId == "" || tag.User_id == userId {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Name != tags[j].Name {
+			return tags[i].Name < tags[j].Name
+		}
+		return lessObjectID(tags[i].ID, tags[j].ID)
+	})
+	return tags, nil
+}
+
+func (s *memoryTagStore) FindById(ctx context.Context, id primitive.ObjectID) (models.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tag, ok := s.tags[id]
+	if !ok {
+		return models.Tag{}, ErrNotFound
+	}
+	return tag, nil
+}
+
+func (s *memoryTagStore) FindByName(ctx context.Context, userId string, name string) (models.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, tag := range s.tags {
+		if tag.User_id == userId && tag.Name == name {
+			return tag, nil
+		}
+	}
+	return models.Tag{}, ErrNotFound
+}
+
+// nameTaken reports whether another tag of the user has the name, which the
+// Mongo store forbids with a unique index.
+func (s *memoryTagStore) nameTaken(tag models.Tag) bool {
+	for _, other := range s.tags {
+		if other.ID != tag.ID && other.User_id == tag.User_id && other.Name == tag.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryTagStore) Insert(ctx context.Context, tag models.Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nameTaken(tag) {
+		return ErrDuplicate
+	}
+	journal(ctx, &s.mu, s.tags, tag.ID, nil)
+	s.tags[tag.ID] = tag
+	return nil
+}
+
+func (s *memoryTagStore) Update(ctx context.Context, tag models.Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tags[tag.ID]; !ok {
+		return ErrNotFound
+	}
+	if s.nameTaken(tag) {
+		return ErrDuplicate
+	}
+	journal(ctx, &s.mu, s.tags, tag.ID, nil)
+	s.tags[tag.ID] = tag
+	return nil
+}
+
+func (s *memoryTagStore) Delete(ctx context.Context, tag models.Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tags[tag.ID]; !ok {
+		return ErrNotFound
+	}
+	journal(ctx, &s.mu, s.tags, tag.ID, nil)
+	delete(s.tags, tag.ID)
+	return nil
+}
+
+func (s *memoryTagStore) DeleteByUser(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, tag := range s.tags {
+		if tag.User_id == userId {
+			journal(ctx, &s.mu, s.tags, id, nil)
+			delete(s.tags, id)
+		}
+	}
+	return nil
+}
+
+type memoryListStore struct {
+	mu    sync.RWMutex
+	lists map[primitive.ObjectID]models.List
+}
+
+func (s *memoryListStore) Find(ctx context.Context, userId string, archived bool) ([]models.List, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lists := []models.List{}
+	for _, list := range s.lists {
+		if (userId == "" || list.User_id == userId) && (list.Archived_at != nil) == archived {
+			lists = append(lists, list)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Position != lists[j].Position {
+			return lists[i].Position < lists[j].Position
+		}
+		return lessObjectID(lists[i].ID, lists[j].ID)
+	})
+	return lists, nil
+}
+
+func (s *memoryListStore) FindById(ctx context.Context, id primitive.ObjectID) (models.List, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list, ok := s.lists[id]
+	if !ok {
+		return models.List{}, ErrNotFound
+	}
+	return list, nil
+}
+
+func (s *memoryListStore) Insert(ctx context.Context, list models.List) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	journal(ctx, &s.mu, s.lists, list.ID, nil)
+	s.lists[list.ID] = list
+	return nil
+}
+
+func (s *memoryListStore) Update(ctx context.Context, list models.List) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lists[list.ID]; !ok {
+		return ErrNotFound
+	}
+	journal(ctx, &s.mu, s.lists, list.ID, nil)
+	s.lists[list.ID] = list
+	return nil
+}
+
+func (s *memoryListStore) Reorder(ctx context.Context, ids []primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := s.lists[id]; !ok {
+			return ErrNotFound
+		}
+	}
+	for position, id := range ids {
+		journal(ctx, &s.mu, s.lists, id, nil)
+		list := s.lists[id]
+		list.Position = position
+		s.lists[id] = list
+	}
+	return nil
+}
+
+func (s *memoryListStore) DeleteByUser(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, list := range s.lists {
+		if list.User_id == userId {
+			journal(ctx, &s.mu, s.lists, id, nil)
+			delete(s.lists, id)
+		}
+	}
+	return nil
+}
+
+type memoryRevisionStore struct {
+	mu        sync.RWMutex
+	revisions map[primitive.ObjectID]models.Revision
+}
+
+func (s *memoryRevisionStore) Find(ctx context.Context, todoId primitive.ObjectID) ([]models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := []models.Revision{}
+	for _, revision := range s.revisions {
+		if revision.Todo_id == todoId {
+			revisions = append(revisions, revision)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool { return lessObjectID(revisions[i].ID, revisions[j].ID) })
+	return revisions, nil
+}
+
+func (s *memoryRevisionStore) FindById(ctx context.Context, id primitive.ObjectID) (models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revision, ok := s.revisions[id]
+	if !ok {
+		return models.Revision{}, ErrNotFound
+	}
+	return revision, nil
+}
+
+func (s *memoryRevisionStore) Insert(ctx context.Context, revision models.Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revision.Snapshot = cloneTodo(revision.Snapshot)
+	journal(ctx, &s.mu, s.revisions, revision.ID, nil)
+	s.revisions[revision.ID] = revision
+	return nil
+}
+
+type memoryIdempotencyStore struct {
+	mu      sync.RWMutex
+	records map[string]models.IdempotencyRecord
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, record models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.records[record.ID]; ok && stored.Expires_at.After(record.Created_at) {
+		return ErrDuplicate
+	}
+	s.records[record.ID] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Find(ctx context.Context, id string) (models.IdempotencyRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return record, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, record models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[record.ID]; !ok {
+		return ErrNotFound
+	}
+	s.records[record.ID] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, id)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for id, record := range s.records {
+		if !record.Expires_at.After(now) {
+			delete(s.records, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+type memoryRevocationStore struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+}
+
+func (s *memoryRevocationStore) Revoke(ctx context.Context, token models.RevokedToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.Token_id] = token.Expires_at
+	return nil
+}
+
+func (s *memoryRevocationStore) IsRevoked(ctx context.Context, tokenId string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.tokens[tokenId]
+	return ok, nil
+}
+
+func (s *memoryRevocationStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for id, expiresAt := range s.tokens {
+		if !expiresAt.After(now) {
+			delete(s.tokens, id)
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,996 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"nitiwat/models"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongoStores returns stores backed by the collections of the named database.
+func NewMongoStores(client *mongo.Client, databaseName string) Stores {
+	return Stores{
+		Todos:        &mongoTodoStore{collection: OpenCollection(client, databaseName, "todos")},
+		Users:        &mongoUserStore{collection: OpenCollection(client, databaseName, "users")},
+		Archive:      &mongoArchiveStore{collection: OpenCollection(client, databaseName, "deleted_users_todo")},
+		Revocations:  &mongoRevocationStore{collection: OpenCollection(client, databaseName, "revoked_tokens")},
+		Tags:         &mongoTagStore{collection: OpenCollection(client, databaseName, "tags")},
+		Lists:        &mongoListStore{collection: OpenCollection(client, databaseName, "lists")},
+		Revisions:    &mongoRevisionStore{collection: OpenCollection(client, databaseName, "todo_revisions")},
+		Idempotency:  &mongoIdempotencyStore{collection: OpenCollection(client, databaseName, "idempotency_keys")},
+		Transactions: &mongoTransactor{client: client},
+		Storage:      &mongoStorage{database: client.Database(databaseName)},
+	}
+}
+
+// mongoCollections names the collections the Mongo stores keep their
+// documents in.
+var mongoCollections = []string{"todos", "users", "deleted_users_todo", "revoked_tokens", "tags", "lists", "todo_revisions", "idempotency_keys"}
+
+// namespaceNotFound is the code of the error Mongo answers commands on a
+// collection that does not exist with.
+const namespaceNotFound = 26
+
+type mongoStorage struct {
+	database *mongo.Database
+}
+
+func (s *mongoStorage) CollectionSizes(ctx context.Context) ([]CollectionSize, error) {
+	sizes := []CollectionSize{}
+	for _, name := range mongoCollections {
+		// the sizes are int32, int64 or double depending on how large they are
+		var stats struct {
+			Count          float64 `bson:"count"`
+			Size           float64 `bson:"size"`
+			StorageSize    float64 `bson:"storageSize"`
+			TotalIndexSize float64 `bson:"totalIndexSize"`
+		}
+		err := s.database.RunCommand(ctx, bson.D{{Key: "collStats", Value: name}}).Decode(&stats)
+		// collections are only created once a document is written to them
+		var commandErr mongo.CommandError
+		if errors.As(err, &commandErr) && commandErr.Code == namespaceNotFound {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, CollectionSize{
+			Name:          name,
+			Documents:     int64(stats.Count),
+			Data_bytes:    int64(stats.Size),
+			Storage_bytes: int64(stats.StorageSize),
+			Index_bytes:   int64(stats.TotalIndexSize),
+		})
+	}
+	return sizes, nil
+}
+
+// mongoTransactor runs functions in Mongo transactions, which needs a replica
+// set or a sharded cluster. NewStores checks for one at startup.
+type mongoTransactor struct {
+	client *mongo.Client
+}
+
+func (t *mongoTransactor) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func mongoError(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+type mongoTodoStore struct {
+	collection *mongo.Collection
+}
+
+func todoQuery(filter TodoFilter) bson.M {
+	query := bson.M{}
+	if filter.User_id != "" {
+		query["user_id"] = filter.User_id
+	}
+	if filter.Check != nil {
+		query["check"] = *filter.Check
+	}
+	if len(filter.Priorities) > 0 {
+		query["priority"] = bson.M{"$in": filter.Priorities}
+	}
+	if len(filter.Tags) > 0 {
+		if filter.AllTags {
+			query["tags"] = bson.M{"$all": filter.Tags}
+		} else {
+			query["tags"] = bson.M{"$in": filter.Tags}
+		}
+	}
+	if filter.Text != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(filter.Text), Options: "i"}
+		query["$or"] = bson.A{bson.M{"title": pattern}, bson.M{"description": pattern}}
+	}
+	if filter.List_id != nil {
+		query["list_id"] = *filter.List_id
+	}
+	if filter.Series_id != nil {
+		query["series_id"] = *filter.Series_id
+	}
+	if r := timeRange(filter.Created_after, filter.Created_before); r != nil {
+		query["created_at"] = r
+	}
+	if r := timeRange(filter.Updated_after, filter.Updated_before); r != nil {
+		query["updated_at"] = r
+	}
+	if filter.Trashed {
+		query["deleted_at"] = bson.M{"$ne": nil}
+	} else if !filter.IncludeTrashed {
+		query["deleted_at"] = nil
+	}
+	if filter.Deleted_before != nil {
+		query["deleted_at"] = bson.M{"$ne": nil, "$lt": *filter.Deleted_before}
+	}
+	if filter.Due_after != nil || filter.Due_before != nil {
+		due := bson.M{"$ne": nil}
+		if filter.Due_after != nil {
+			due["$gte"] = *filter.Due_after
+		}
+		if filter.Due_before != nil {
+			due["$lt"] = *filter.Due_before
+		}
+		query["due_at"] = due
+	}
+	return query
+}
+
+// timeRange matches times in [after, before), or returns nil when unbounded.
+func timeRange(after *time.Time, before *time.Time) bson.M {
+	if after == nil && before == nil {
+		return nil
+	}
+	r := bson.M{}
+	if after != nil {
+		r["$gte"] = *after
+	}
+	if before != nil {
+		r["$lt"] = *before
+	}
+	return r
+}
+
+func sortQuery(fields []SortField, idField string) bson.D {
+	sort := bson.D{}
+	for _, field := range fields {
+		direction := 1
+		if field.Desc {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: field.Field, Value: direction})
+	}
+	return append(sort, bson.E{Key: idField, Value: 1})
+}
+
+// todoSortValue returns the stored value of a sortable field, nil when unset.
+func todoSortValue(todo models.Todo, field string) interface{} {
+	switch field {
+	case "due_at":
+		if todo.Due_at == nil {
+			return nil
+		}
+		return *todo.Due_at
+	case "created_at":
+		return todo.Created_at
+	case "updated_at":
+		return todo.Updated_at
+	case "priority":
+		return todo.Priority
+	case "title":
+		return todo.Title
+	case "position":
+		// todos created before positions existed have none
+		if todo.Position == "" {
+			return nil
+		}
+		return todo.Position
+	case "check":
+		return todo.Check
+	}
+	return nil
+}
+
+// afterQuery matches the documents that follow the position in sort order:
+// for each field, the documents equal on every earlier field and strictly
+// after on that one, and finally those equal on all of them with a greater
+// id. Missing values sort first, as they do in Mongo.
+func afterQuery(fields []SortField, idField string, values []interface{}, id primitive.ObjectID) bson.M {
+	branches := bson.A{}
+	equal := bson.A{}
+	for i, field := range fields {
+		value := values[i]
+		var after bson.M
+		switch {
+		case value == nil && !field.Desc:
+			after = bson.M{field.Field: bson.M{"$ne": nil}}
+		case value == nil:
+			// Nothing sorts after a missing value in descending order.
+		case !field.Desc:
+			after = bson.M{field.Field: bson.M{"$gt": value}}
+		default:
+			after = bson.M{"$or": bson.A{bson.M{field.Field: bson.M{"$lt": value}}, bson.M{field.Field: nil}}}
+		}
+		if after != nil {
+			branches = append(branches, bson.M{"$and": append(append(bson.A{}, equal...), after)})
+		}
+		equal = append(equal, bson.M{field.Field: value})
+	}
+	branches = append(branches, bson.M{"$and": append(equal, bson.M{idField: bson.M{"$gt": id}})})
+	return bson.M{"$or": branches}
+}
+
+// find opens a cursor over the todos matched by filter.
+func (s *mongoTodoStore) find(ctx context.Context, filter TodoFilter) (*mongo.Cursor, error) {
+	opts := options.Find().SetSort(sortQuery(filter.Sort, "id"))
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+	query := todoQuery(filter)
+	if filter.After != nil {
+		values := []interface{}{}
+		for _, field := range filter.Sort {
+			values = append(values, todoSortValue(*filter.After, field.Field))
+		}
+		query = bson.M{"$and": bson.A{query, afterQuery(filter.Sort, "id", values, filter.After.ID)}}
+	}
+	return s.collection.Find(ctx, query, opts)
+}
+
+func (s *mongoTodoStore) Find(ctx context.Context, filter TodoFilter) ([]models.Todo, error) {
+	cursor, err := s.find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	todos := []models.Todo{}
+	if err = cursor.All(ctx, &todos); err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+func (s *mongoTodoStore) Each(ctx context.Context, filter TodoFilter, fn func(todo models.Todo) error) error {
+	cursor, err := s.find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var todo models.Todo
+		if err := cursor.Decode(&todo); err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *mongoTodoStore) Count(ctx context.Context, filter TodoFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, todoQuery(filter))
+}
+
+func (s *mongoTodoStore) FindById(ctx context.Context, id primitive.ObjectID) (models.Todo, error) {
+	var todo models.Todo
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&todo)
+	return todo, mongoError(err)
+}
+
+func (s *mongoTodoStore) FindByTitle(ctx context.Context, userId string, title string) (models.Todo, error) {
+	var todo models.Todo
+	err := s.collection.FindOne(ctx, bson.M{"title": title, "user_id": userId, "deleted_at": nil}).Decode(&todo)
+	return todo, mongoError(err)
+}
+
+func (s *mongoTodoStore) Insert(ctx context.Context, todo models.Todo) error {
+	_, err := s.collection.InsertOne(ctx, todo)
+	return err
+}
+
+// versionQuery narrows query to the documents still at the version before
+// version. Documents saved before versions were introduced have none and
+// count as 0.
+func versionQuery(query bson.M, version int64) bson.M {
+	versioned := bson.M{"version": version - 1}
+	if version-1 == 0 {
+		versioned["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	for key, value := range query {
+		versioned[key] = value
+	}
+	return versioned
+}
+
+// replaceVersioned replaces the document matched by query if it is still at
+// the version before the one being saved.
+func replaceVersioned(ctx context.Context, collection *mongo.Collection, query bson.M, version int64, document interface{}) error {
+	result, err := collection.ReplaceOne(ctx, versionQuery(query, version), document)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	count, err := collection.CountDocuments(ctx, query)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+func (s *mongoTodoStore) Update(ctx context.Context, todo models.Todo) error {
+	return replaceVersioned(ctx, s.collection, bson.M{"id": todo.ID}, todo.Version, todo)
+}
+
+func (s *mongoTodoStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTodoStore) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userId})
+	return err
+}
+
+// DeleteMany deletes the matched todos one by one, matching each against the
+// filter again, so a todo changed since it was found, such as one restored
+// from the trash, is neither removed nor reported.
+func (s *mongoTodoStore) DeleteMany(ctx context.Context, filter TodoFilter) ([]primitive.ObjectID, error) {
+	query := todoQuery(filter)
+	cursor, err := s.collection.Find(ctx, query, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var found []struct {
+		ID primitive.ObjectID `bson:"id"`
+	}
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	ids := []primitive.ObjectID{}
+	for _, todo := range found {
+		result, err := s.collection.DeleteOne(ctx, bson.M{"$and": bson.A{bson.M{"id": todo.ID}, query}})
+		if err != nil {
+			return ids, err
+		}
+		if result.DeletedCount > 0 {
+			ids = append(ids, todo.ID)
+		}
+	}
+	return ids, nil
+}
+
+// CreateIndexes creates the indexes the Mongo stores rely on:
+//   - the text index Search uses. Its language is none, so words are neither
+//     stemmed nor dropped as stop words and match the way Tokenize splits them.
+//   - a unique index on the jti of revoked tokens, which Authenticate looks up
+//     on every request.
+//   - a unique index on the names of each user's tags, which makes a tag
+//     created or renamed concurrently with another of the same name fail.
+//   - TTL indexes that drop revoked tokens and idempotency records once they
+//     expire, between the runs of the cleanup worker.
+func CreateIndexes(ctx context.Context, client *mongo.Client, databaseName string) error {
+	indexes := []struct {
+		collection string
+		model      mongo.IndexModel
+	}{
+		{"todos", mongo.IndexModel{
+			Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().SetName("todos_text").SetDefaultLanguage("none"),
+		}},
+		{"tags", mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetName("tags_user_name").SetUnique(true),
+		}},
+		{"revoked_tokens", mongo.IndexModel{
+			Keys:    bson.D{{Key: "token_id", Value: 1}},
+			Options: options.Index().SetName("revoked_tokens_token_id").SetUnique(true),
+		}},
+		{"revoked_tokens", mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("revoked_tokens_ttl").SetExpireAfterSeconds(0),
+		}},
+		{"idempotency_keys", mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("idempotency_keys_ttl").SetExpireAfterSeconds(0),
+		}},
+	}
+	for _, index := range indexes {
+		if _, err := OpenCollection(client, databaseName, index.collection).Indexes().CreateOne(ctx, index.model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchCandidates caps the candidates Mongo hands Search to rank, so a
+// common word does not load every todo that holds it.
+const searchCandidates = 1000
+
+// Search finds the candidates with the text index, quoting every word so the
+// index requires all of them, and a word-start regular expression for each
+// prefix, which the index cannot match. Mongo sorts them by its text score,
+// or by last update for a search of prefixes only, and returns the first
+// searchCandidates of them, which are then ranked the same way as by the
+// memory store.
+func (s *mongoTodoStore) Search(ctx context.Context, userId string, query SearchQuery, limit int64) ([]SearchHit, error) {
+	filter := bson.M{"deleted_at": nil}
+	if userId != "" {
+		filter["user_id"] = userId
+	}
+
+	search := ""
+	for _, word := range query.Words {
+		search += `"` + word + `" `
+	}
+	for _, phrase := range query.Phrases {
+		search += `"` + strings.Join(phrase, " ") + `" `
+	}
+	if search != "" {
+		filter["$text"] = bson.M{"$search": search}
+	}
+
+	prefixes := bson.A{}
+	for _, prefix := range query.Prefixes {
+		pattern := primitive.Regex{Pattern: `(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(prefix), Options: "i"}
+		prefixes = append(prefixes, bson.M{"$or": bson.A{bson.M{"title": pattern}, bson.M{"description": pattern}}})
+	}
+	if len(prefixes) > 0 {
+		filter["$and"] = prefixes
+	}
+
+	opts := options.Find().SetLimit(searchCandidates)
+	if search != "" {
+		score := bson.M{"$meta": "textScore"}
+		opts.SetProjection(bson.M{"score": score}).SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}})
+	} else {
+		opts.SetSort(bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: 1}})
+	}
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	todos := []models.Todo{}
+	if err = cursor.All(ctx, &todos); err != nil {
+		return nil, err
+	}
+	return rankHits(todos, query, limit), nil
+}
+
+func (s *mongoTodoStore) CountByList(ctx context.Context, userId string) (map[primitive.ObjectID]ListCounts, error) {
+	match := bson.M{"list_id": bson.M{"$ne": nil}, "deleted_at": nil}
+	if userId != "" {
+		match["user_id"] = userId
+	}
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":  "$list_id",
+			"open": bson.M{"$sum": bson.M{"$cond": bson.A{"$check", 0, 1}}},
+			"done": bson.M{"$sum": bson.M{"$cond": bson.A{"$check", 1, 0}}},
+		}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		Open int64              `bson:"open"`
+		Done int64              `bson:"done"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := map[primitive.ObjectID]ListCounts{}
+	for _, row := range rows {
+		counts[row.ID] = ListCounts{Open: row.Open, Done: row.Done}
+	}
+	return counts, nil
+}
+
+func (s *mongoTodoStore) CountByUser(ctx context.Context) (map[string]int64, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"deleted_at": nil}},
+		bson.M{"$group": bson.M{"_id": "$user_id", "count": bson.M{"$sum": 1}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID    string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.ID] = row.Count
+	}
+	return counts, nil
+}
+
+type mongoUserStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoUserStore) FindById(ctx context.Context, userId string) (models.User, error) {
+	var user models.User
+	err := s.collection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user)
+	return user, mongoError(err)
+}
+
+func (s *mongoUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	var user models.User
+	err := s.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	return user, mongoError(err)
+}
+
+func (s *mongoUserStore) Count(ctx context.Context, filter UserFilter) (int64, error) {
+	query := bson.M{}
+	if filter.Email != nil {
+		query["email"] = *filter.Email
+	}
+	if filter.Phone != nil {
+		query["phone"] = *filter.Phone
+	}
+	if filter.User_type != nil {
+		query["user_type"] = *filter.User_type
+	}
+	if filter.Updated_after != nil {
+		query["updated_at"] = bson.M{"$gte": *filter.Updated_after}
+	}
+	return s.collection.CountDocuments(ctx, query)
+}
+
+func (s *mongoUserStore) CountCreatedByDay(ctx context.Context, since time.Time, loc *time.Location) (map[string]int64, error) {
+	day := bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at", "timezone": loc.String()}}
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"created_at": bson.M{"$gte": since}}},
+		bson.M{"$group": bson.M{"_id": day, "count": bson.M{"$sum": 1}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID    string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.ID] = row.Count
+	}
+	return counts, nil
+}
+
+// idPage returns the query and options that list a collection in id order
+// after the given id.
+func idPage(idField string, after *primitive.ObjectID, limit int64) (bson.M, *options.FindOptions) {
+	query := bson.M{}
+	if after != nil {
+		query[idField] = bson.M{"$gt": *after}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: idField, Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	return query, opts
+}
+
+func (s *mongoUserStore) List(ctx context.Context, after *primitive.ObjectID, limit int64) ([]models.User, error) {
+	query, opts := idPage("_id", after, limit)
+	cursor, err := s.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	users := []models.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *mongoUserStore) Insert(ctx context.Context, user models.User) error {
+	_, err := s.collection.InsertOne(ctx, user)
+	return err
+}
+
+func (s *mongoUserStore) Update(ctx context.Context, user models.User) error {
+	return replaceVersioned(ctx, s.collection, bson.M{"user_id": user.User_id}, user.Version, user)
+}
+
+func (s *mongoUserStore) Delete(ctx context.Context, userId string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userId})
+	return err
+}
+
+type mongoArchiveStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoArchiveStore) Find(ctx context.Context, after *primitive.ObjectID, limit int64) ([]models.DeleteModal, error) {
+	query, opts := idPage("id", after, limit)
+	cursor, err := s.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	archives := []models.DeleteModal{}
+	if err = cursor.All(ctx, &archives); err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+// Archives written before deleted_at was recorded have no such field and are
+// never matched, since their age is unknown.
+func (s *mongoArchiveStore) FindDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.DeleteModal, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return nil, err
+	}
+	archives := []models.DeleteModal{}
+	if err = cursor.All(ctx, &archives); err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+func (s *mongoArchiveStore) Count(ctx context.Context, deletedBefore *time.Time) (int64, error) {
+	query := bson.M{}
+	if deletedBefore != nil {
+		query["deleted_at"] = bson.M{"$lt": *deletedBefore}
+	}
+	return s.collection.CountDocuments(ctx, query)
+}
+
+func (s *mongoArchiveStore) FindById(ctx context.Context, id primitive.ObjectID) (models.DeleteModal, error) {
+	var archive models.DeleteModal
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&archive)
+	return archive, mongoError(err)
+}
+
+func (s *mongoArchiveStore) Insert(ctx context.Context, archive models.DeleteModal) error {
+	_, err := s.collection.InsertOne(ctx, archive)
+	return err
+}
+
+func (s *mongoArchiveStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteDeletedBefore deletes the archives one by one like
+// mongoTodoStore.DeleteMany, reading only the ids of their todos.
+func (s *mongoArchiveStore) DeleteDeletedBefore(ctx context.Context, cutoff time.Time) (archives int64, todoIds []primitive.ObjectID, err error) {
+	query := bson.M{"deleted_at": bson.M{"$lt": cutoff}}
+	cursor, err := s.collection.Find(ctx, query, options.Find().SetProjection(bson.M{"id": 1, "todos.id": 1}))
+	if err != nil {
+		return 0, nil, err
+	}
+	var found []struct {
+		ID    primitive.ObjectID `bson:"id"`
+		Todos []struct {
+			ID primitive.ObjectID `bson:"id"`
+		} `bson:"todos"`
+	}
+	if err = cursor.All(ctx, &found); err != nil {
+		return 0, nil, err
+	}
+
+	todoIds = []primitive.ObjectID{}
+	for _, archive := range found {
+		result, err := s.collection.DeleteOne(ctx, bson.M{"id": archive.ID, "deleted_at": bson.M{"$lt": cutoff}})
+		if err != nil {
+			return archives, todoIds, err
+		}
+		if result.DeletedCount == 0 {
+			continue
+		}
+		archives++
+		for _, todo := range archive.Todos {
+			todoIds = append(todoIds, todo.ID)
+		}
+	}
+	return archives, todoIds, nil
+}
+
+type mongoTagStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoTagStore) Find(ctx context.Context, userId string) ([]models.Tag, error) {
+	query := bson.M{}
+	if userId != "" {
+		query["user_id"] = userId
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	tags := []models.Tag{}
+	if err = cursor.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *mongoTagStore) FindById(ctx context.Context, id primitive.ObjectID) (models.Tag, error) {
+	var tag models.Tag
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&tag)
+	return tag, mongoError(err)
+}
+
+func (s *mongoTagStore) FindByName(ctx context.Context, userId string, name string) (models.Tag, error) {
+	var tag models.Tag
+	err := s.collection.FindOne(ctx, bson.M{"user_id": userId, "name": name}).Decode(&tag)
+	return tag, mongoError(err)
+}
+
+func (s *mongoTagStore) Insert(ctx context.Context, tag models.Tag) error {
+	_, err := s.collection.InsertOne(ctx, tag)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *mongoTagStore) Update(ctx context.Context, tag models.Tag) error {
+	result, err := s.collection.ReplaceOne(ctx, bson.M{"id": tag.ID}, tag)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTagStore) Delete(ctx context.Context, tag models.Tag) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"id": tag.ID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTagStore) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userId})
+	return err
+}
+
+type mongoListStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoListStore) Find(ctx context.Context, userId string, archived bool) ([]models.List, error) {
+	query := bson.M{"archived_at": nil}
+	if archived {
+		query["archived_at"] = bson.M{"$ne": nil}
+	}
+	if userId != "" {
+		query["user_id"] = userId
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	lists := []models.List{}
+	if err = cursor.All(ctx, &lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+func (s *mongoListStore) FindById(ctx context.Context, id primitive.ObjectID) (models.List, error) {
+	var list models.List
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&list)
+	return list, mongoError(err)
+}
+
+func (s *mongoListStore) Insert(ctx context.Context, list models.List) error {
+	_, err := s.collection.InsertOne(ctx, list)
+	return err
+}
+
+func (s *mongoListStore) Update(ctx context.Context, list models.List) error {
+	result, err := s.collection.ReplaceOne(ctx, bson.M{"id": list.ID}, list)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoListStore) Reorder(ctx context.Context, ids []primitive.ObjectID) error {
+	writes := []mongo.WriteModel{}
+	for position, id := range ids {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"id": id}).
+			SetUpdate(bson.M{"$set": bson.M{"position": position}}))
+	}
+	result, err := s.collection.BulkWrite(ctx, writes)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount < int64(len(ids)) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoListStore) DeleteByUser(ctx context.Context, userId string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userId})
+	return err
+}
+
+type mongoRevisionStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRevisionStore) Find(ctx context.Context, todoId primitive.ObjectID) ([]models.Revision, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, bson.M{"todo_id": todoId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	revisions := []models.Revision{}
+	if err = cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (s *mongoRevisionStore) FindById(ctx context.Context, id primitive.ObjectID) (models.Revision, error) {
+	var revision models.Revision
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&revision)
+	return revision, mongoError(err)
+}
+
+func (s *mongoRevisionStore) Insert(ctx context.Context, revision models.Revision) error {
+	_, err := s.collection.InsertOne(ctx, revision)
+	return err
+}
+
+// mongoIdempotencyStore keeps records under their id as _id, which Mongo
+// keeps unique, so two requests cannot both reserve a key.
+type mongoIdempotencyStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoIdempotencyStore) Reserve(ctx context.Context, record models.IdempotencyRecord) error {
+	// an expired record no longer holds the key
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": record.ID, "expires_at": bson.M{"$lte": record.Created_at}})
+	if err != nil {
+		return err
+	}
+	_, err = s.collection.InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *mongoIdempotencyStore) Find(ctx context.Context, id string) (models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	return record, mongoError(err)
+}
+
+func (s *mongoIdempotencyStore) Complete(ctx context.Context, record models.IdempotencyRecord) error {
+	result, err := s.collection.ReplaceOne(ctx, bson.M{"_id": record.ID}, record)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoIdempotencyStore) Delete(ctx context.Context, id string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (s *mongoIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result, err := s.collection.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+type mongoRevocationStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRevocationStore) Revoke(ctx context.Context, token models.RevokedToken) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"token_id": token.Token_id}, token, opts)
+	return err
+}
+
+func (s *mongoRevocationStore) IsRevoked(ctx context.Context, tokenId string) (bool, error) {
+	count, err := s.collection.CountDocuments(ctx, bson.M{"token_id": tokenId})
+	return count > 0, err
+}
+
+func (s *mongoRevocationStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result, err := s.collection.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// MigrateTodos gives the todos stored before priorities existed the priority
+// 0 they are read with. Mongo sorts and matches a missing priority as null,
+// below 0, while the memory store takes it as 0.
+func MigrateTodos(ctx context.Context, client *mongo.Client, databaseName string) error {
+	_, err := OpenCollection(client, databaseName, "todos").UpdateMany(ctx,
+		bson.M{"priority": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"priority": 0}},
+	)
+	return err
+}
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"nitiwat/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchQuery is a parsed search: words must appear as whole words, prefixes
+// as the start of a word and phrases as consecutive words. A todo matches when
+// its title or description holds every one of them.
+type SearchQuery struct {
+	Words    []string
+	Prefixes []string
+	Phrases  [][]string
+}
+
+// Empty reports whether the query has nothing to search for.
+func (q SearchQuery) Empty() bool {
+	return len(q.Words) == 0 && len(q.Prefixes) == 0 && len(q.Phrases) == 0
+}
+
+// SearchHit is a todo found by a search with its relevance score.
+type SearchHit struct {
+	Todo  models.Todo
+	Score float64
+}
+
+// Token is a word of a text, lowercased, with the byte offsets of where it
+// appears in the text.
+type Token struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// Tokenize splits text into words: runs of letters and digits. Everything
+// else separates words, so this matches a Mongo text index with the language
+// set to none.
+func Tokenize(text string) []Token {
+	tokens := []Token{}
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			tokens = append(tokens, Token{Text: strings.ToLower(text[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, Token{Text: strings.ToLower(text[start:]), Start: start, End: len(text)})
+	}
+	return tokens
+}
+
+func tokenTexts(text string) []string {
+	texts := []string{}
+	for _, token := range Tokenize(text) {
+		texts = append(texts, token.Text)
+	}
+	return texts
+}
+
+// ParseSearch parses a search string. Text in double quotes is a phrase, a
+// word ending in * a prefix and any other word a word. A quoted text of a
+// single word counts as that word.
+func ParseSearch(text string) SearchQuery {
+	var query SearchQuery
+	parts := strings.Split(text, `"`)
+	for i, part := range parts {
+		// odd parts are inside quotes, unless the last quote is unpaired
+		if i%2 == 1 && i < len(parts)-1 {
+			phrase := tokenTexts(part)
+			switch len(phrase) {
+			case 0:
+			case 1:
+				query.Words = append(query.Words, phrase[0])
+			default:
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			prefix := strings.HasSuffix(field, "*")
+			words := tokenTexts(field)
+			for j, word := range words {
+				if prefix && j == len(words)-1 {
+					query.Prefixes = append(query.Prefixes, word)
+				} else {
+					query.Words = append(query.Words, word)
+				}
+			}
+		}
+	}
+	return query
+}
+
+// PhraseAt reports whether the phrase starts at the i-th word.
+func PhraseAt(words []string, i int, phrase []string) bool {
+	if i+len(phrase) > len(words) {
+		return false
+	}
+	for j, word := range phrase {
+		if words[i+j] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// termCounts counts how often each word, prefix and phrase of the query
+// occurs among the words of a text, in that order.
+func termCounts(words []string, query SearchQuery) []int {
+	counts := make([]int, len(query.Words)+len(query.Prefixes)+len(query.Phrases))
+	for i, word := range words {
+		n := 0
+		for _, w := range query.Words {
+			if word == w {
+				counts[n]++
+			}
+			n++
+		}
+		for _, p := range query.Prefixes {
+			if strings.HasPrefix(word, p) {
+				counts[n]++
+			}
+			n++
+		}
+		for _, phrase := range query.Phrases {
+			if PhraseAt(words, i, phrase) {
+				counts[n]++
+			}
+			n++
+		}
+	}
+	return counts
+}
+
+// Field weights of the relevance score: a match in the title counts more than
+// one in the description.
+const (
+	titleWeight       = 3
+	descriptionWeight = 1
+)
+
+// scoreTodo returns the relevance of the todo for the query, or false when it
+// does not match. Each term found in a field adds the weight of the field,
+// half of it flat and half in proportion to how much of the field the term
+// takes up; phrases count once per word.
+func scoreTodo(todo models.Todo, query SearchQuery) (float64, bool) {
+	fields := []struct {
+		words  []string
+		weight float64
+	}{
+		{tokenTexts(todo.Title), titleWeight},
+		{tokenTexts(todo.Description), descriptionWeight},
+	}
+
+	terms := len(query.Words) + len(query.Prefixes)
+	found := make([]bool, terms+len(query.Phrases))
+	score := 0.0
+	for _, field := range fields {
+		for n, count := range termCounts(field.words, query) {
+			if count == 0 {
+				continue
+			}
+			found[n] = true
+			size := 1
+			if n >= terms {
+				size = len(query.Phrases[n-terms])
+			}
+			score += field.weight * float64(size) * (0.5 + 0.5*float64(count*size)/float64(len(field.words)))
+		}
+	}
+	for _, ok := range found {
+		if !ok {
+			return 0, false
+		}
+	}
+	return score, true
+}
+
+// rankHits scores the todos for the query, drops the ones that do not match
+// and orders the rest by relevance, then by last update, keeping at most limit
+// of them when limit is not 0.
+func rankHits(todos []models.Todo, query SearchQuery, limit int64) []SearchHit {
+	hits := []SearchHit{}
+	for _, todo := range todos {
+		if score, ok := scoreTodo(todo, query); ok {
+			hits = append(hits, SearchHit{Todo: todo, Score: score})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if !hits[i].Todo.Updated_at.Equal(hits[j].Todo.Updated_at) {
+			return hits[i].Todo.Updated_at.After(hits[j].Todo.Updated_at)
+		}
+		return lessObjectID(hits[i].Todo.ID, hits[j].Todo.ID)
+	})
+	if limit > 0 && int64(len(hits)) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"nitiwat/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		text string
+		want []Token
+	}{
+		{"", []Token{}},
+		{"  ", []Token{}},
+		{"Buy milk", []Token{{"buy", 0, 3}, {"milk", 4, 8}}},
+		{"e-mail, ASAP!", []Token{{"e", 0, 1}, {"mail", 2, 6}, {"asap", 8, 12}}},
+		{"room 101", []Token{{"room", 0, 4}, {"101", 5, 8}}},
+		{"café au lait", []Token{{"café", 0, 5}, {"au", 6, 8}, {"lait", 9, 13}}},
+	}
+	for _, c := range cases {
+		if got := Tokenize(c.text); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Tokenize(%q) = %v, want %v", c.text, got, c.want)
+		}
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	cases := []struct {
+		text string
+		want SearchQuery
+	}{
+		{"", SearchQuery{}},
+		{"  \t", SearchQuery{}},
+		{"Milk", SearchQuery{Words: []string{"milk"}}},
+		{"buy milk", SearchQuery{Words: []string{"buy", "milk"}}},
+		{"mil*", SearchQuery{Prefixes: []string{"mil"}}},
+		{"buy mil*", SearchQuery{Words: []string{"buy"}, Prefixes: []string{"mil"}}},
+		{"e-mai*", SearchQuery{Words: []string{"e"}, Prefixes: []string{"mai"}}},
+		{"*", SearchQuery{}},
+		{`"buy milk"`, SearchQuery{Phrases: [][]string{{"buy", "milk"}}}},
+		{`"Milk"`, SearchQuery{Words: []string{"milk"}}},
+		{`""`, SearchQuery{}},
+		{`today "buy oat milk" mil*`, SearchQuery{Words: []string{"today"}, Prefixes: []string{"mil"}, Phrases: [][]string{{"buy", "oat", "milk"}}}},
+		// an unpaired quote is ignored
+		{`"buy milk`, SearchQuery{Words: []string{"buy", "milk"}}},
+		{`"buy milk" "oat`, SearchQuery{Words: []string{"oat"}, Phrases: [][]string{{"buy", "milk"}}}},
+		// a star inside a phrase is punctuation
+		{`"buy mil*"`, SearchQuery{Phrases: [][]string{{"buy", "mil"}}}},
+	}
+	for _, c := range cases {
+		if got := ParseSearch(c.text); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseSearch(%q) = %+v, want %+v", c.text, got, c.want)
+		}
+	}
+}
+
+func TestRankHits(t *testing.T) {
+	now := time.Now()
+	todo := func(title string, description string, updated time.Duration) models.Todo {
+		return models.Todo{ID: primitive.NewObjectID(), Title: title, Description: description, Updated_at: now.Add(-updated)}
+	}
+	inTitle := todo("Buy milk", "At the shop", time.Hour)
+	inDescription := todo("Groceries", "Buy milk and bread", time.Hour)
+	shortTitle := todo("Milk", "", 2*time.Hour)
+	reversed := todo("Milk buy", "", time.Hour)
+	older := todo("Buy milk", "At the shop", 2*time.Hour)
+	unrelated := todo("Walk the dog", "", 0)
+	todos := []models.Todo{unrelated, older, reversed, inDescription, shortTitle, inTitle}
+
+	cases := []struct {
+		name  string
+		query string
+		limit int64
+		want  []models.Todo
+	}{
+		{"title before description, then newer first", "milk", 0, []models.Todo{shortTitle, inTitle, reversed, older, inDescription}},
+		{"every word is required", "buy milk", 0, []models.Todo{inTitle, reversed, older, inDescription}},
+		{"phrase in order", `"buy milk"`, 0, []models.Todo{inTitle, older, inDescription}},
+		{"prefix", "gro*", 0, []models.Todo{inDescription}},
+		{"word is not a prefix", "gro", 0, []models.Todo{}},
+		{"limit", "milk", 2, []models.Todo{shortTitle, inTitle}},
+		{"no match", "cheese", 0, []models.Todo{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hits := rankHits(todos, ParseSearch(c.query), c.limit)
+			got := []models.Todo{}
+			for i, hit := range hits {
+				got = append(got, hit.Todo)
+				if i > 0 && hit.Score > hits[i-1].Score {
+					t.Errorf("hit %d scores more than the one before it", i)
+				}
+			}
+			if !reflect.DeepEqual(todoIds(got), todoIds(c.want)) {
+				t.Errorf("the hits are %v, want %v", titles(got), titles(c.want))
+			}
+		})
+	}
+}
+
+func titles(todos []models.Todo) []string {
+	names := []string{}
+	for _, todo := range todos {
+		names = append(names, todo.Title+" / "+todo.Description)
+	}
+	return names
+}
+
+func TestTodoStoreSearch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		now := time.Now().Truncate(time.Millisecond)
+		todo := func(user string, title string, description string) models.Todo {
+			return models.Todo{ID: primitive.NewObjectID(), User_id: user, Title: title, Description: description, Created_at: now, Updated_at: now}
+		}
+		milk := todo("u1", "Buy milk", "Two litres")
+		bread := todo("u1", "Bakery", "Buy bread and milk")
+		theirs := todo("u2", "Buy milk", "")
+		trashed := todo("u1", "Milk the cow", "")
+		trashed.Deleted_at = &now
+		for _, todo := range []models.Todo{milk, bread, theirs, trashed} {
+			if err := stores.Todos.Insert(ctx, todo); err != nil {
+				t.Fatalf("Insert: %v", err)
+			}
+		}
+
+		cases := []struct {
+			name   string
+			userId string
+			query  string
+			limit  int64
+			want   []models.Todo
+		}{
+			{"user", "u1", "milk", 0, []models.Todo{milk, bread}},
+			{"all users, ties by id", "", "buy milk", 0, []models.Todo{milk, theirs, bread}},
+			{"prefix", "u1", "bak*", 0, []models.Todo{bread}},
+			{"phrase", "u1", `"bread and milk"`, 0, []models.Todo{bread}},
+			{"limit", "u1", "milk", 1, []models.Todo{milk}},
+			{"trash is left out", "u1", "cow", 0, []models.Todo{}},
+		}
+		for _, c := range cases {
+			hits, err := stores.Todos.Search(ctx, c.userId, ParseSearch(c.query), c.limit)
+			if err != nil {
+				t.Fatalf("%s: Search: %v", c.name, err)
+			}
+			got := []models.Todo{}
+			for _, hit := range hits {
+				got = append(got, hit.Todo)
+			}
+			if !reflect.DeepEqual(todoIds(got), todoIds(c.want)) {
+				t.Errorf("%s: the hits are %v, want %v", c.name, titles(got), titles(c.want))
+			}
+		}
+	})
+}
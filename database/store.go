@@ -0,0 +1,230 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"nitiwat/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned by every store when no document matches the lookup.
+var ErrNotFound = errors.New("document not found")
+
+// ErrVersionConflict is returned by the Update methods of the versioned stores
+// when the document was saved by someone else since it was loaded.
+var ErrVersionConflict = errors.New("document was changed since it was loaded")
+
+// ErrDuplicate is returned when a document cannot be stored because one with
+// the same id already exists.
+var ErrDuplicate = errors.New("document already exists")
+
+// TodoFilter narrows the todos returned by TodoStore.Find and TodoStore.Count.
+// Zero values mean "no restriction".
+type TodoFilter struct {
+	User_id    string
+	Check      *bool
+	Priorities []int
+	// Text matches todos whose title or description contains it, ignoring case.
+	Text           string
+	Created_after  *time.Time
+	Created_before *time.Time
+	Updated_after  *time.Time
+	Updated_before *time.Time
+	// Trashed selects the todos in the trash instead of the live ones, and
+	// IncludeTrashed selects both.
+	Trashed        bool
+	IncludeTrashed bool
+	// Deleted_before matches the todos put in the trash before then, so it
+	// selects trashed todos on its own.
+	Deleted_before *time.Time
+	// Due_after and Due_before bound due_at to [Due_after, Due_before).
+	Due_after  *time.Time
+	Due_before *time.Time
+	// Tags matches todos carrying any of the tags, or all of them when
+	// AllTags is set.
+	Tags      []string
+	AllTags   bool
+	List_id   *primitive.ObjectID
+	Series_id *primitive.ObjectID
+	Sort      []SortField
+	// After resumes the listing after this todo in Sort order; only its sort
+	// fields and id are read. Limit caps the number of todos returned.
+	After *models.Todo
+	Limit int64
+}
+
+// SortField orders results by one document field. Documents that tie on every
+// sort field are ordered by id, and missing values sort first.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// UserFilter narrows the users counted by UserStore.Count.
+type UserFilter struct {
+	Email     *string
+	Phone     *string
+	User_type *string
+	// Updated_after matches users saved since then, which logging in and
+	// refreshing tokens do.
+	Updated_after *time.Time
+}
+
+// ListCounts holds the number of open and done live todos of one list.
+type ListCounts struct {
+	Open int64
+	Done int64
+}
+
+type TodoStore interface {
+	Find(ctx context.Context, filter TodoFilter) ([]models.Todo, error)
+	// Each calls fn with the todos Find would return one at a time, without
+	// loading them all first, and stops at the first error fn returns.
+	Each(ctx context.Context, filter TodoFilter, fn func(todo models.Todo) error) error
+	Count(ctx context.Context, filter TodoFilter) (int64, error)
+	FindById(ctx context.Context, id primitive.ObjectID) (models.Todo, error)
+	FindByTitle(ctx context.Context, userId string, title string) (models.Todo, error)
+	Insert(ctx context.Context, todo models.Todo) error
+	// Update saves a todo whose Version was incremented from the one it was
+	// loaded with, and fails with ErrVersionConflict when the stored todo is
+	// no longer at that version.
+	Update(ctx context.Context, todo models.Todo) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	DeleteByUser(ctx context.Context, userId string) error
+	// DeleteMany removes the todos matched by filter and returns the ids of
+	// the ones it removed.
+	DeleteMany(ctx context.Context, filter TodoFilter) ([]primitive.ObjectID, error)
+	// Search returns the live todos of the user (every user when userId is
+	// empty) matching the query, most relevant first.
+	Search(ctx context.Context, userId string, query SearchQuery, limit int64) ([]SearchHit, error)
+	// CountByList counts the live todos of the user (every user when userId
+	// is empty) per list.
+	CountByList(ctx context.Context, userId string) (map[primitive.ObjectID]ListCounts, error)
+	// CountByUser counts the live todos of every user who has any.
+	CountByUser(ctx context.Context) (map[string]int64, error)
+}
+
+type UserStore interface {
+	FindById(ctx context.Context, userId string) (models.User, error)
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+	Count(ctx context.Context, filter UserFilter) (int64, error)
+	// CountCreatedByDay counts the users created since the given time per
+	// calendar day in loc, keyed by date as YYYY-MM-DD.
+	CountCreatedByDay(ctx context.Context, since time.Time, loc *time.Location) (map[string]int64, error)
+	// List returns users in id order, starting after the given id when it is
+	// not nil. A limit of 0 means no limit.
+	List(ctx context.Context, after *primitive.ObjectID, limit int64) ([]models.User, error)
+	Insert(ctx context.Context, user models.User) error
+	// Update saves a user like TodoStore.Update.
+	Update(ctx context.Context, user models.User) error
+	Delete(ctx context.Context, userId string) error
+}
+
+type ArchiveStore interface {
+	// Find returns archives in id order like UserStore.List.
+	Find(ctx context.Context, after *primitive.ObjectID, limit int64) ([]models.DeleteModal, error)
+	FindDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.DeleteModal, error)
+	// Count counts the archives, or only the ones deleted before the cutoff
+	// when it is not nil.
+	Count(ctx context.Context, deletedBefore *time.Time) (int64, error)
+	FindById(ctx context.Context, id primitive.ObjectID) (models.DeleteModal, error)
+	Insert(ctx context.Context, archive models.DeleteModal) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// DeleteDeletedBefore removes the archives deleted before the cutoff and
+	// returns how many it removed and the ids of the todos they held.
+	DeleteDeletedBefore(ctx context.Context, cutoff time.Time) (archives int64, todoIds []primitive.ObjectID, err error)
+}
+
+// TagStore keeps the tags of every user. Renaming, merging and deleting a tag
+// only change the tags; the caller retags the todos carrying it in the same
+// transaction.
+type TagStore interface {
+	Find(ctx context.Context, userId string) ([]models.Tag, error)
+	FindById(ctx context.Context, id primitive.ObjectID) (models.Tag, error)
+	FindByName(ctx context.Context, userId string, name string) (models.Tag, error)
+	// Insert and Update fail with ErrDuplicate when another tag of the user
+	// has the name.
+	Insert(ctx context.Context, tag models.Tag) error
+	Update(ctx context.Context, tag models.Tag) error
+	Delete(ctx context.Context, tag models.Tag) error
+	DeleteByUser(ctx context.Context, userId string) error
+}
+
+type ListStore interface {
+	// Find returns the lists of the user (every user when userId is empty) in
+	// position order, either the live or the archived ones.
+	Find(ctx context.Context, userId string, archived bool) ([]models.List, error)
+	FindById(ctx context.Context, id primitive.ObjectID) (models.List, error)
+	Insert(ctx context.Context, list models.List) error
+	Update(ctx context.Context, list models.List) error
+	// Reorder gives the lists their index in ids as position.
+	Reorder(ctx context.Context, ids []primitive.ObjectID) error
+	DeleteByUser(ctx context.Context, userId string) error
+}
+
+type RevisionStore interface {
+	// Find returns the revisions of a todo, oldest first.
+	Find(ctx context.Context, todoId primitive.ObjectID) ([]models.Revision, error)
+	FindById(ctx context.Context, id primitive.ObjectID) (models.Revision, error)
+	Insert(ctx context.Context, revision models.Revision) error
+}
+
+type RevocationStore interface {
+	Revoke(ctx context.Context, token models.RevokedToken) error
+	IsRevoked(ctx context.Context, tokenId string) (bool, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// IdempotencyStore keeps the responses to requests sent with an
+// Idempotency-Key, by record id.
+type IdempotencyStore interface {
+	// Reserve stores the record of a new request, failing with ErrDuplicate
+	// while an unexpired record with the same id exists.
+	Reserve(ctx context.Context, record models.IdempotencyRecord) error
+	Find(ctx context.Context, id string) (models.IdempotencyRecord, error)
+	// Complete replaces the record once the response is known.
+	Complete(ctx context.Context, record models.IdempotencyRecord) error
+	Delete(ctx context.Context, id string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Transactor runs fn so that the changes it makes to users, archives, todos,
+// tags, lists and revisions through the context it is given apply together or
+// not at all: when fn returns an error they are rolled back. A transaction
+// started with the context of another joins it, and fn may be run again from
+// the start when the transaction has to be retried.
+type Transactor interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// CollectionSize is the number of documents of a collection and the bytes
+// they, and the indexes on them, take up.
+type CollectionSize struct {
+	Name          string `json:"name"`
+	Documents     int64  `json:"documents"`
+	Data_bytes    int64  `json:"data_bytes"`
+	Storage_bytes int64  `json:"storage_bytes"`
+	Index_bytes   int64  `json:"index_bytes"`
+}
+
+// StorageInspector reports how much each collection of the stores holds.
+type StorageInspector interface {
+	CollectionSizes(ctx context.Context) ([]CollectionSize, error)
+}
+
+// Stores groups the storage backends used by the controllers so a single
+// value can be handed to them at startup.
+type Stores struct {
+	Todos        TodoStore
+	Users        UserStore
+	Archive      ArchiveStore
+	Revocations  RevocationStore
+	Tags         TagStore
+	Lists        ListStore
+	Revisions    RevisionStore
+	Idempotency  IdempotencyStore
+	Transactions Transactor
+	Storage      StorageInspector
+}
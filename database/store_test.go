@@ -0,0 +1,319 @@
+package database
+
+import (
+	"context"
+	"nitiwat/models"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// connectMongo connects to the Mongo server named by MONGO_DB_URL and
+// returns a fresh database on it, dropped when the test ends. The test is
+// skipped when the variable is not set.
+func connectMongo(t *testing.T) (*mongo.Client, string) {
+	url := os.Getenv("MONGO_DB_URL")
+	if url == "" {
+		t.Skip("MONGO_DB_URL is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatalf("connecting to Mongo: %v", err)
+	}
+	name := "nitiwat_test_" + primitive.NewObjectID().Hex()
+	t.Cleanup(func() {
+		client.Database(name).Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	if err := CheckTransactions(ctx, client); err != nil {
+		t.Fatalf("checking the Mongo server: %v", err)
+	}
+	if err := CreateIndexes(ctx, client, name); err != nil {
+		t.Fatalf("creating indexes: %v", err)
+	}
+	return client, name
+}
+
+// backends are the store implementations every contract test runs against.
+var backends = []struct {
+	name string
+	open func(t *testing.T) Stores
+}{
+	{"memory", func(t *testing.T) Stores { return NewMemoryStores() }},
+	{"mongo", func(t *testing.T) Stores { return NewMongoStores(connectMongo(t)) }},
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, stores Stores)) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+func todoIds(todos []models.Todo) []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for _, todo := range todos {
+		ids = append(ids, todo.ID)
+	}
+	return ids
+}
+
+func sameIds(a []primitive.ObjectID, b []primitive.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTodoStoreFind(t *testing.T) {
+	// Mongo keeps milliseconds, so the times are whole seconds
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) *time.Time {
+		t := base.Add(time.Duration(hours) * time.Hour)
+		return &t
+	}
+	list := primitive.NewObjectID()
+	todo := func(userId string, title string, priority int, created int) models.Todo {
+		return models.Todo{
+			ID:         primitive.NewObjectID(),
+			User_id:    userId,
+			Title:      title,
+			Priority:   priority,
+			Created_at: *at(created),
+			Updated_at: *at(created),
+			Tags:       []string{},
+		}
+	}
+
+	milk := todo("u1", "Buy milk", 2, 0)
+	milk.Tags = []string{"home"}
+	report := todo("u1", "Write report", 1, 1)
+	report.Description = "with the MILK figures"
+	report.Check = true
+	report.Tags = []string{"work", "home"}
+	report.Due_at = at(48)
+	call := todo("u1", "Call Bob", 0, 2)
+	call.Tags = []string{"work"}
+	call.Deleted_at = at(2)
+	bread := todo("u2", "Buy bread", 2, 3)
+	trip := todo("u1", "Plan trip", 3, 4)
+	trip.Due_at = at(24)
+	trip.List_id = &list
+	todos := []models.Todo{milk, report, call, bread, trip}
+
+	yes := true
+	cases := []struct {
+		name   string
+		filter TodoFilter
+		want   []models.Todo
+	}{
+		{"user", TodoFilter{User_id: "u1"}, []models.Todo{milk, report, trip}},
+		{"all users", TodoFilter{}, []models.Todo{milk, report, bread, trip}},
+		{"check", TodoFilter{Check: &yes}, []models.Todo{report}},
+		{"priorities", TodoFilter{User_id: "u1", Priorities: []int{2, 3}}, []models.Todo{milk, trip}},
+		{"any tag", TodoFilter{Tags: []string{"home"}}, []models.Todo{milk, report}},
+		{"all tags", TodoFilter{Tags: []string{"work", "home"}, AllTags: true, IncludeTrashed: true}, []models.Todo{report}},
+		{"text", TodoFilter{Text: "milk"}, []models.Todo{milk, report}},
+		{"created range", TodoFilter{Created_after: at(1), Created_before: at(4)}, []models.Todo{report, bread}},
+		{"trashed", TodoFilter{User_id: "u1", Trashed: true}, []models.Todo{call}},
+		{"include trashed", TodoFilter{User_id: "u1", IncludeTrashed: true}, []models.Todo{milk, report, call, trip}},
+		{"deleted before", TodoFilter{Deleted_before: at(3)}, []models.Todo{call}},
+		{"due range", TodoFilter{Due_after: at(0), Due_before: at(48)}, []models.Todo{trip}},
+		{"list", TodoFilter{List_id: &list}, []models.Todo{trip}},
+		{"sort desc", TodoFilter{User_id: "u1", Sort: []SortField{{Field: "priority", Desc: true}}}, []models.Todo{trip, milk, report}},
+		{"sort ties by id", TodoFilter{Sort: []SortField{{Field: "priority", Desc: true}}}, []models.Todo{trip, milk, bread, report}},
+		{"limit", TodoFilter{Sort: []SortField{{Field: "priority", Desc: true}}, Limit: 3}, []models.Todo{trip, milk, bread}},
+		{"missing sorts first", TodoFilter{User_id: "u1", Sort: []SortField{{Field: "due_at"}}}, []models.Todo{milk, trip, report}},
+		{"after", TodoFilter{User_id: "u1", Sort: []SortField{{Field: "priority"}}, After: &milk}, []models.Todo{trip}},
+		{"after a tie", TodoFilter{Sort: []SortField{{Field: "priority", Desc: true}}, After: &milk}, []models.Todo{bread, report}},
+		{"after missing", TodoFilter{User_id: "u1", Sort: []SortField{{Field: "due_at"}}, After: &milk}, []models.Todo{trip, report}},
+	}
+
+	forEachBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		for _, todo := range todos {
+			if err := stores.Todos.Insert(ctx, todo); err != nil {
+				t.Fatalf("inserting %q: %v", todo.Title, err)
+			}
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				found, err := stores.Todos.Find(ctx, c.filter)
+				if err != nil {
+					t.Fatalf("Find: %v", err)
+				}
+				if got, want := todoIds(found), todoIds(c.want); !sameIds(got, want) {
+					t.Errorf("Find returned %v, want %v", got, want)
+				}
+				if c.filter.After != nil || c.filter.Limit > 0 {
+					return
+				}
+				count, err := stores.Todos.Count(ctx, c.filter)
+				if err != nil {
+					t.Fatalf("Count: %v", err)
+				}
+				if count != int64(len(c.want)) {
+					t.Errorf("Count returned %d, want %d", count, len(c.want))
+				}
+			})
+		}
+	})
+}
+
+func TestTodoStoreUpdate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		todo := models.Todo{ID: primitive.NewObjectID(), User_id: "u1", Title: "Buy milk", Tags: []string{}}
+		if err := stores.Todos.Insert(ctx, todo); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+
+		saved := todo
+		saved.Title = "Buy oat milk"
+		saved.Version++
+		if err := stores.Todos.Update(ctx, saved); err != nil {
+			t.Fatalf("Update at the next version: %v", err)
+		}
+		stale := todo
+		stale.Title = "Buy soy milk"
+		stale.Version++
+		if err := stores.Todos.Update(ctx, stale); err != ErrVersionConflict {
+			t.Errorf("Update of a stale todo returned %v, want ErrVersionConflict", err)
+		}
+		found, err := stores.Todos.FindById(ctx, todo.ID)
+		if err != nil {
+			t.Fatalf("FindById: %v", err)
+		}
+		if found.Title != saved.Title || found.Version != 1 {
+			t.Errorf("FindById returned %q at version %d, want %q at version 1", found.Title, found.Version, saved.Title)
+		}
+
+		missing := models.Todo{ID: primitive.NewObjectID(), Version: 1}
+		if err := stores.Todos.Update(ctx, missing); err != ErrNotFound {
+			t.Errorf("Update of a missing todo returned %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoresNotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		id := primitive.NewObjectID()
+		lookups := []struct {
+			name string
+			err  error
+		}{
+			{"todo by id", func() error { _, err := stores.Todos.FindById(ctx, id); return err }()},
+			{"todo by title", func() error { _, err := stores.Todos.FindByTitle(ctx, "u1", "Buy milk"); return err }()},
+			{"user by id", func() error { _, err := stores.Users.FindById(ctx, id.Hex()); return err }()},
+			{"user by email", func() error { _, err := stores.Users.FindByEmail(ctx, "ann@example.com"); return err }()},
+			{"archive by id", func() error { _, err := stores.Archive.FindById(ctx, id); return err }()},
+			{"tag by id", func() error { _, err := stores.Tags.FindById(ctx, id); return err }()},
+			{"tag by name", func() error { _, err := stores.Tags.FindByName(ctx, "u1", "home"); return err }()},
+			{"tag update", stores.Tags.Update(ctx, models.Tag{ID: id, User_id: "u1", Name: "home"})},
+			{"tag delete", stores.Tags.Delete(ctx, models.Tag{ID: id})},
+			{"revision by id", func() error { _, err := stores.Revisions.FindById(ctx, id); return err }()},
+			{"idempotency record", func() error { _, err := stores.Idempotency.Find(ctx, "u1:key"); return err }()},
+		}
+		for _, lookup := range lookups {
+			if lookup.err != ErrNotFound {
+				t.Errorf("%s returned %v, want ErrNotFound", lookup.name, lookup.err)
+			}
+		}
+	})
+}
+
+func TestStoresDuplicate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		home := models.Tag{ID: primitive.NewObjectID(), User_id: "u1", Name: "home"}
+		work := models.Tag{ID: primitive.NewObjectID(), User_id: "u1", Name: "work"}
+		for _, tag := range []models.Tag{home, work, {ID: primitive.NewObjectID(), User_id: "u2", Name: "home"}} {
+			if err := stores.Tags.Insert(ctx, tag); err != nil {
+				t.Fatalf("inserting tag %q of %s: %v", tag.Name, tag.User_id, err)
+			}
+		}
+
+		again := models.Tag{ID: primitive.NewObjectID(), User_id: "u1", Name: "home"}
+		if err := stores.Tags.Insert(ctx, again); err != ErrDuplicate {
+			t.Errorf("Insert of a taken tag name returned %v, want ErrDuplicate", err)
+		}
+		work.Name = "home"
+		if err := stores.Tags.Update(ctx, work); err != ErrDuplicate {
+			t.Errorf("Update onto a taken tag name returned %v, want ErrDuplicate", err)
+		}
+
+		now := time.Now().Truncate(time.Second)
+		record := models.IdempotencyRecord{ID: "u1:key", Created_at: now, Expires_at: now.Add(time.Hour)}
+		if err := stores.Idempotency.Reserve(ctx, record); err != nil {
+			t.Fatalf("Reserve: %v", err)
+		}
+		if err := stores.Idempotency.Reserve(ctx, record); err != ErrDuplicate {
+			t.Errorf("Reserve of a held key returned %v, want ErrDuplicate", err)
+		}
+		later := record
+		later.Created_at = record.Expires_at
+		later.Expires_at = later.Created_at.Add(time.Hour)
+		if err := stores.Idempotency.Reserve(ctx, later); err != nil {
+			t.Errorf("Reserve of an expired key returned %v, want nil", err)
+		}
+	})
+}
+
+// TestMigrateTodos checks that todos stored before priorities existed sort
+// the way the memory store has them once migrated, as priority 0.
+func TestMigrateTodos(t *testing.T) {
+	client, name := connectMongo(t)
+	ctx := context.Background()
+	stores := NewMongoStores(client, name)
+
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	low := models.Todo{ID: primitive.NewObjectID(), User_id: "u1", Title: "Low", Created_at: created, Updated_at: created}
+	legacy := models.Todo{ID: primitive.NewObjectID(), User_id: "u1", Title: "Legacy", Created_at: created, Updated_at: created}
+	high := models.Todo{ID: primitive.NewObjectID(), User_id: "u1", Title: "High", Priority: 1, Created_at: created, Updated_at: created}
+
+	document, err := bson.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bson.M
+	if err := bson.Unmarshal(document, &raw); err != nil {
+		t.Fatal(err)
+	}
+	delete(raw, "priority")
+	if err := stores.Todos.Insert(ctx, low); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenCollection(client, name, "todos").InsertOne(ctx, raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Todos.Insert(ctx, high); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateTodos(ctx, client, name); err != nil {
+		t.Fatalf("MigrateTodos: %v", err)
+	}
+	found, err := stores.Todos.Find(ctx, TodoFilter{Sort: []SortField{{Field: "priority"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := todoIds(found), todoIds([]models.Todo{low, legacy, high}); !sameIds(got, want) {
+		t.Errorf("Find sorted by priority returned %v, want %v", got, want)
+	}
+}
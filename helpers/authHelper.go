@@ -34,3 +34,24 @@ func MatchUserTypeToUid(c *gin.Context, userId string) (err error) {
 	return err
 
 }
+
+// TodoOwner returns the id of the user whose todos the request acts on. That
+// is always the authenticated user unless an ADMIN names another one with the
+// as_user query parameter; as_user=all returns "" meaning every user.
+func TodoOwner(c *gin.Context) (userId string, err error) {
+	override, ok := c.GetQuery("as_user")
+	if !ok {
+		return c.GetString("uid"), nil
+	}
+
+	if err = CheckUserType(c, "ADMIN"); err != nil {
+		return "", err
+	}
+	if override == "all" {
+		return "", nil
+	}
+	if override == "" {
+		return "", errors.New("as_user must name a user or be all")
+	}
+	return override, nil
+}
@@ -0,0 +1,33 @@
+package helpers
+
+import (
+	"errors"
+	"nitiwat/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserLocation returns the timezone a request's calendar days are computed
+// in: the tz query parameter, else the user's saved timezone, else UTC.
+func UserLocation(c *gin.Context, user models.User) (*time.Location, error) {
+	name := c.Query("tz")
+	if name == "" && user.Timezone != nil {
+		name = *user.Timezone
+	}
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.New("unknown timezone " + name)
+	}
+	return loc, nil
+}
+
+// StartOfDay returns midnight at the start of t's calendar day in loc.
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
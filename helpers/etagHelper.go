@@ -0,0 +1,57 @@
+package helpers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrPreconditionFailed = errors.New("the resource was changed, fetch it again and retry")
+var ErrPreconditionRequired = errors.New("an If-Match header with the resource's ETag is required")
+
+// ETag returns the entity tag of a resource at the given version.
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// matchesETag reports whether a list of entity tags as sent in If-Match or
+// If-None-Match names the version. Weak tags compare like strong ones since
+// the versions are the only tags handed out.
+func matchesETag(header string, version int64) bool {
+	etag := ETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckIfMatch checks the If-Match header of a request changing a resource at
+// the given version. A missing header passes unless the configuration
+// requires it.
+func CheckIfMatch(c *gin.Context, version int64) error {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		if cfg.RequireIfMatch {
+			return ErrPreconditionRequired
+		}
+		return nil
+	}
+	if !matchesETag(header, version) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// NotModified sets the ETag of a resource read at the given version and
+// reports whether the If-None-Match header of the request already names it,
+// in which case the caller answers 304 without a body.
+func NotModified(c *gin.Context, version int64) bool {
+	c.Header("ETag", ETag(version))
+	header := c.GetHeader("If-None-Match")
+	return header != "" && matchesETag(header, version)
+}
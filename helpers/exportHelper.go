@@ -0,0 +1,115 @@
+package helpers
+
+import (
+	"encoding/json"
+	"io"
+	"nitiwat/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	ExportCSV    = "csv"
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+// ExportContentTypes gives the content type each export format is served
+// with.
+var ExportContentTypes = map[string]string{
+	ExportCSV:    "text/csv; charset=utf-8",
+	ExportJSON:   "application/json; charset=utf-8",
+	ExportNDJSON: "application/x-ndjson",
+}
+
+// TodoCSVHeader names the columns of TodoCSVRecord.
+var TodoCSVHeader = []string{
+	"id", "user_id", "title", "description", "check", "priority", "tags", "list_id",
+	"due_at", "timezone", "completed_at", "created_at", "updated_at", "deleted_at",
+	"series_id", "recurrence", "items",
+}
+
+// TodoCSVRecord flattens a todo into a CSV row. Times are RFC 3339 in UTC and
+// empty when unset, tags are joined with commas and the recurrence and items
+// are given in their JSON form.
+func TodoCSVRecord(todo models.Todo) ([]string, error) {
+	recurrence := ""
+	if todo.Recurrence != nil {
+		data, err := json.Marshal(todo.Recurrence)
+		if err != nil {
+			return nil, err
+		}
+		recurrence = string(data)
+	}
+	items := ""
+	if len(todo.Items) > 0 {
+		data, err := json.Marshal(todo.Items)
+		if err != nil {
+			return nil, err
+		}
+		items = string(data)
+	}
+	listId := ""
+	if todo.List_id != nil {
+		listId = todo.List_id.Hex()
+	}
+	seriesId := ""
+	if todo.Series_id != nil {
+		seriesId = todo.Series_id.Hex()
+	}
+
+	return []string{
+		todo.ID.Hex(),
+		todo.User_id,
+		todo.Title,
+		todo.Description,
+		strconv.FormatBool(todo.Check),
+		strconv.Itoa(todo.Priority),
+		strings.Join(todo.Tags, ","),
+		listId,
+		exportTime(todo.Due_at),
+		todo.Timezone,
+		exportTime(todo.Completed_at),
+		exportTime(&todo.Created_at),
+		exportTime(&todo.Updated_at),
+		exportTime(todo.Deleted_at),
+		seriesId,
+		recurrence,
+		items,
+	}, nil
+}
+
+// WriteCSVRecord writes a CSV row the way RFC 4180 has it: fields holding a
+// comma, a double quote or a line break are quoted, with quotes doubled, and
+// the row ends with CRLF. Unlike encoding/csv with UseCRLF, line breaks
+// inside fields are kept as they are.
+func WriteCSVRecord(w io.Writer, fields []string) error {
+	var row strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			row.WriteByte(',')
+		}
+		if strings.ContainsAny(field, ",\"\r\n") {
+			row.WriteString(`"` + strings.ReplaceAll(field, `"`, `""`) + `"`)
+		} else {
+			row.WriteString(field)
+		}
+	}
+	row.WriteString("\r\n")
+	_, err := io.WriteString(w, row.String())
+	return err
+}
+
+func exportTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ExportFilename is the name an export of the given format is saved under.
+func ExportFilename(format string, now time.Time) string {
+	return "todos-" + now.UTC().Format("2006-01-02") + "." + format
+}
@@ -0,0 +1,125 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"nitiwat/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWriteCSVRecord(t *testing.T) {
+	cases := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{"plain", []string{"a", "b c", "1"}, "a,b c,1\r\n"},
+		{"empty fields", []string{"", "", ""}, ",,\r\n"},
+		{"no fields", []string{}, "\r\n"},
+		{"comma", []string{"milk, bread"}, "\"milk, bread\"\r\n"},
+		{"quote", []string{`say "hi"`}, "\"say \"\"hi\"\"\"\r\n"},
+		{"only a quote", []string{`"`}, "\"\"\"\"\r\n"},
+		{"line feed", []string{"one\ntwo", "x"}, "\"one\ntwo\",x\r\n"},
+		{"carriage return", []string{"one\r\ntwo"}, "\"one\r\ntwo\"\r\n"},
+		{"spaces are kept", []string{" padded "}, " padded \r\n"},
+		{"single quotes and semicolons", []string{"it's; fine"}, "it's; fine\r\n"},
+		{"unicode", []string{"ซื้อนม", "café"}, "ซื้อนม,café\r\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := WriteCSVRecord(&b, c.fields); err != nil {
+				t.Fatalf("WriteCSVRecord: %v", err)
+			}
+			if b.String() != c.want {
+				t.Errorf("WriteCSVRecord wrote %q, want %q", b.String(), c.want)
+			}
+		})
+	}
+}
+
+// TestWriteCSVRecordReadsBack checks the rows against encoding/csv, which
+// reads a line break inside a quoted field as a line feed.
+func TestWriteCSVRecordReadsBack(t *testing.T) {
+	rows := [][]string{
+		{"id", "title", "description"},
+		{"1", `The "big" one`, "first line\nsecond, line"},
+		{"2", "", "trailing comma,"},
+	}
+	var b bytes.Buffer
+	for _, row := range rows {
+		if err := WriteCSVRecord(&b, row); err != nil {
+			t.Fatalf("WriteCSVRecord: %v", err)
+		}
+	}
+	read, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the rows back: %v", err)
+	}
+	if !reflect.DeepEqual(read, rows) {
+		t.Errorf("the rows read back as %q, want %q", read, rows)
+	}
+}
+
+func TestTodoCSVRecord(t *testing.T) {
+	created := time.Date(2026, 1, 5, 16, 0, 0, 0, time.FixedZone("ICT", 7*60*60))
+	due := time.Date(2026, 1, 6, 9, 0, 0, 0, time.UTC)
+	id, list, series := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	itemId := primitive.NewObjectID()
+
+	cases := []struct {
+		name string
+		todo models.Todo
+		want []string
+	}{
+		{
+			"bare",
+			models.Todo{ID: id, User_id: "u1", Title: "Buy milk", Created_at: created, Updated_at: created},
+			[]string{id.Hex(), "u1", "Buy milk", "", "false", "0", "", "", "", "", "", "2026-01-05T09:00:00Z", "2026-01-05T09:00:00Z", "", "", "", ""},
+		},
+		{
+			"full",
+			models.Todo{
+				ID: id, User_id: "u1", Title: "Buy milk", Description: "Two, please", Check: true, Priority: models.PriorityHigh,
+				Tags: []string{"home", "shop"}, List_id: &list, Due_at: &due, Timezone: "Asia/Bangkok", Completed_at: &due,
+				Created_at: created, Updated_at: due, Deleted_at: &due, Series_id: &series,
+				Recurrence: &models.Recurrence{Frequency: models.RepeatDaily, Interval: 1},
+				Items:      []models.ChecklistItem{{ID: itemId, Title: "Oat", Check: true}},
+			},
+			[]string{
+				id.Hex(), "u1", "Buy milk", "Two, please", "true", "3", "home,shop", list.Hex(),
+				"2026-01-06T09:00:00Z", "Asia/Bangkok", "2026-01-06T09:00:00Z", "2026-01-05T09:00:00Z", "2026-01-06T09:00:00Z", "2026-01-06T09:00:00Z",
+				series.Hex(), `{"frequency":"daily","interval":1,"weekdays":null,"month_day":0}`,
+				`[{"id":"` + itemId.Hex() + `","title":"Oat","check":true,"position":0}]`,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			record, err := TodoCSVRecord(c.todo)
+			if err != nil {
+				t.Fatalf("TodoCSVRecord: %v", err)
+			}
+			if len(record) != len(TodoCSVHeader) {
+				t.Fatalf("the record has %d fields for %d columns", len(record), len(TodoCSVHeader))
+			}
+			for i, field := range record {
+				if field != c.want[i] {
+					t.Errorf("%s is %q, want %q", TodoCSVHeader[i], field, c.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestExportFilename checks that the file is named after the day in UTC.
+func TestExportFilename(t *testing.T) {
+	now := time.Date(2026, 1, 5, 23, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	if got := ExportFilename(ExportCSV, now); got != "todos-2026-01-06.csv" {
+		t.Errorf("ExportFilename = %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package helpers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"nitiwat/database"
+	"nitiwat/models"
+	"time"
+)
+
+var ErrIdempotencyKeyReused = errors.New("this Idempotency-Key was already used for a different request")
+var ErrIdempotencyInProgress = errors.New("a request with this Idempotency-Key is still being processed")
+
+// keyedHash returns the HMAC-SHA256 of data keyed with the signing key, so
+// what was hashed, such as the password in a signup request, cannot be
+// guessed and checked against the stored hash.
+func keyedHash(data ...[]byte) string {
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY))
+	for _, part := range data {
+		mac.Write(part)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RequestFingerprint identifies a request by its method, path and body, so a
+// retry can be told apart from another request reusing the key.
+func RequestFingerprint(method string, path string, body []byte) string {
+	return keyedHash([]byte(method+" "+path+"\n"), body)
+}
+
+// IdempotencyScope returns what an Idempotency-Key is scoped to: the user who
+// sent it or, for requests sent before logging in, the address of the
+// client, hashed so the records do not hold it. User ids never start with
+// the colon of the latter.
+func IdempotencyScope(userId string, clientIP string) string {
+	if userId != "" {
+		return userId
+	}
+	return ":" + keyedHash([]byte(clientIP))
+}
+
+func idempotencyID(scope string, key string) string {
+	return scope + ":" + key
+}
+
+// BeginIdempotentRequest reserves the key of a request in its scope. It
+// returns nil when the request is new and should be handled, or the record
+// of the earlier request whose response should be replayed.
+func BeginIdempotentRequest(scope string, key string, fingerprint string) (*models.IdempotencyRecord, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	now := time.Now()
+	record := models.IdempotencyRecord{
+		ID:          idempotencyID(scope, key),
+		Fingerprint: fingerprint,
+		Created_at:  now,
+		Expires_at:  now.Add(cfg.IdempotencyTTL),
+	}
+	err := idempotencyStore.Reserve(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+	if err != database.ErrDuplicate {
+		return nil, err
+	}
+
+	stored, err := idempotencyStore.Find(ctx, record.ID)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !stored.Completed {
+		return nil, ErrIdempotencyInProgress
+	}
+	return &stored, nil
+}
+
+// FinishIdempotentRequest stores the response to a request reserved with
+// BeginIdempotentRequest for its retries.
+func FinishIdempotentRequest(scope string, key string, fingerprint string, status int, contentType string, body []byte) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	now := time.Now()
+	return idempotencyStore.Complete(ctx, models.IdempotencyRecord{
+		ID:           idempotencyID(scope, key),
+		Fingerprint:  fingerprint,
+		Completed:    true,
+		Status:       status,
+		Content_type: contentType,
+		Body:         body,
+		Created_at:   now,
+		Expires_at:   now.Add(cfg.IdempotencyTTL),
+	})
+}
+
+// AbandonIdempotentRequest releases the key of a request that failed on the
+// server side or never finished, so a retry is handled again instead of
+// replaying the failure.
+func AbandonIdempotentRequest(scope string, key string, fingerprint string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	return idempotencyStore.Delete(ctx, idempotencyID(scope, key))
+}
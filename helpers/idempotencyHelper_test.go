@@ -0,0 +1,53 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestRequestFingerprintIsKeyed(t *testing.T) {
+	defer func(key string) { SECRET_KEY = key }(SECRET_KEY)
+	body := []byte(`{"email": "ann@example.com", "Password": "secret123"}`)
+
+	SECRET_KEY = "one"
+	fingerprint := RequestFingerprint("POST", "/users/signup", body)
+	plain := sha256.Sum256(append([]byte("POST /users/signup\n"), body...))
+	if fingerprint == hex.EncodeToString(plain[:]) {
+		t.Errorf("the fingerprint is the plain hash of the request")
+	}
+
+	cases := []struct {
+		name         string
+		key          string
+		method, path string
+		body         string
+		same         bool
+	}{
+		{"same request", "one", "POST", "/users/signup", string(body), true},
+		{"other key", "two", "POST", "/users/signup", string(body), false},
+		{"other body", "one", "POST", "/users/signup", `{"email": "ann@example.com", "Password": "secret124"}`, false},
+		{"other path", "one", "POST", "/todos", string(body), false},
+		{"other method", "one", "PUT", "/users/signup", string(body), false},
+	}
+	for _, c := range cases {
+		SECRET_KEY = c.key
+		if got := RequestFingerprint(c.method, c.path, []byte(c.body)); (got == fingerprint) != c.same {
+			t.Errorf("%s: the fingerprints match is %v, want %v", c.name, got == fingerprint, c.same)
+		}
+	}
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	if scope := IdempotencyScope("u1", "10.0.0.1"); scope != "u1" {
+		t.Errorf("a user's scope is %q, want their id", scope)
+	}
+	scope := IdempotencyScope("", "10.0.0.1")
+	if !strings.HasPrefix(scope, ":") || strings.Contains(scope, "10.0.0.1") {
+		t.Errorf("an anonymous scope is %q", scope)
+	}
+	if IdempotencyScope("", "10.0.0.2") == scope {
+		t.Errorf("two clients share the scope %q", scope)
+	}
+}
@@ -0,0 +1,27 @@
+package helpers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// PageLimit returns the limit query parameter of a paginated listing, or
+// DefaultPageLimit when it is absent.
+func PageLimit(c *gin.Context) (int64, error) {
+	param := c.Query("limit")
+	if param == "" {
+		return DefaultPageLimit, nil
+	}
+	limit, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || limit < 1 || limit > MaxPageLimit {
+		return 0, errors.New("limit must be a number between 1 and " + strconv.Itoa(MaxPageLimit))
+	}
+	return limit, nil
+}
@@ -0,0 +1,302 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Content types of the two patch formats PATCH requests may use.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// ErrInvalidPatch is returned for a patch document that is not well formed.
+var ErrInvalidPatch = errors.New("invalid patch document")
+
+// ErrPatchTestFailed is returned when a "test" operation of a JSON Patch does
+// not hold, so none of the patch was applied.
+var ErrPatchTestFailed = errors.New("patch test operation failed")
+
+// MergePatch applies a JSON Merge Patch (RFC 7396) to a JSON document.
+func MergePatch(document []byte, patch []byte) ([]byte, error) {
+	var doc, p interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return json.Marshal(mergeValue(doc, p))
+}
+
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(object, name)
+			continue
+		}
+		object[name] = mergeValue(object[name], value)
+	}
+	return object
+}
+
+// patchOperation is one operation of a JSON Patch.
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// JSONPatch applies a JSON Patch (RFC 6902) to a JSON document. The
+// operations apply in order and the patch is applied entirely or not at all.
+func JSONPatch(document []byte, patch []byte) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+	var operations []patchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for i, op := range operations {
+		if op.Path == nil {
+			return nil, fmt.Errorf("%w: operation %d has no path", ErrInvalidPatch, i)
+		}
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%w: %s operation %d has no value", ErrInvalidPatch, op.Op, i)
+			}
+			if err := json.Unmarshal(*op.Value, &value); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+			}
+		case "move", "copy":
+			if op.From == nil {
+				return nil, fmt.Errorf("%w: %s operation %d has no from", ErrInvalidPatch, op.Op, i)
+			}
+			from, err := parsePointer(*op.From)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = getPointer(doc, from); err != nil {
+				return nil, err
+			}
+			if op.Op == "move" {
+				if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+					return nil, fmt.Errorf("cannot move %s into itself", *op.From)
+				}
+				if doc, err = removePointer(doc, from); err != nil {
+					return nil, err
+				}
+			} else {
+				value = copyValue(value)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+		}
+
+		switch op.Op {
+		case "add", "move", "copy":
+			doc, err = addPointer(doc, path, value)
+		case "remove":
+			doc, err = removePointer(doc, path)
+		case "replace":
+			if _, err = getPointer(doc, path); err == nil {
+				doc, err = setPointer(doc, path, value)
+			}
+		case "test":
+			var current interface{}
+			if current, err = getPointer(doc, path); err == nil && !reflect.DeepEqual(current, value) {
+				err = fmt.Errorf("%w: %s", ErrPatchTestFailed, *op.Path)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex resolves a pointer token into an index of an array of the given
+// length. "-" stands for the end of the array, which only adding accepts.
+func arrayIndex(token string, length int, adding bool) (int, error) {
+	if token == "-" && adding {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	limit := length - 1
+	if adding {
+		limit = length
+	}
+	if index > limit {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+func getPointer(doc interface{}, path []string) (interface{}, error) {
+	current := doc
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path member %q does not exist", token)
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("path member %q does not exist", token)
+		}
+	}
+	return current, nil
+}
+
+// updatePointer replaces the parent of the last token of path with what
+// change returns for it, rebuilding the document along the way.
+func updatePointer(doc interface{}, path []string, change func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return change(doc, path[0])
+	}
+	child, err := getPointer(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = updatePointer(child, path[1:], change)
+	if err != nil {
+		return nil, err
+	}
+	return setPointer(doc, path[:1], child)
+}
+
+func setPointer(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updatePointer(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			node[index] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("path member %q does not exist", token)
+	})
+}
+
+func addPointer(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updatePointer(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("path member %q does not exist", token)
+	})
+}
+
+func removePointer(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	return updatePointer(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("path member %q does not exist", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index], node[index+1:]...), nil
+		}
+		return nil, fmt.Errorf("path member %q does not exist", token)
+	})
+}
+
+// copyValue deep-copies a decoded JSON value so a copied part of a document
+// can be changed without changing its source.
+func copyValue(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		copied := map[string]interface{}{}
+		for name, member := range node {
+			copied[name] = copyValue(member)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(node))
+		for i, member := range node {
+			copied[i] = copyValue(member)
+		}
+		return copied
+	}
+	return value
+}
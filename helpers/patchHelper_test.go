@@ -0,0 +1,134 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// errAny stands for any error that is neither ErrInvalidPatch nor
+// ErrPatchTestFailed, such as a path that does not exist.
+var errAny = errors.New("any error")
+
+// sameJSON reports whether two JSON documents hold the same value.
+func sameJSON(t *testing.T, a []byte, b string) bool {
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatalf("decoding %s: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		t.Fatalf("decoding %s: %v", b, err)
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func checkPatchError(t *testing.T, err error, want error) {
+	t.Helper()
+	switch {
+	case want == errAny:
+		if err == nil || errors.Is(err, ErrInvalidPatch) || errors.Is(err, ErrPatchTestFailed) {
+			t.Errorf("the patch failed with %v, want an error about the document", err)
+		}
+	case !errors.Is(err, want):
+		t.Errorf("the patch failed with %v, want %v", err, want)
+	}
+}
+
+// The cases are the examples of RFC 7396, appendix A.
+func TestMergePatch(t *testing.T) {
+	cases := []struct {
+		document, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, c := range cases {
+		got, err := MergePatch([]byte(c.document), []byte(c.patch))
+		if err != nil {
+			t.Errorf("MergePatch(%s, %s): %v", c.document, c.patch, err)
+			continue
+		}
+		if !sameJSON(t, got, c.want) {
+			t.Errorf("MergePatch(%s, %s) = %s, want %s", c.document, c.patch, got, c.want)
+		}
+	}
+
+	if _, err := MergePatch([]byte(`{}`), []byte(`{"a":`)); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("a malformed merge patch failed with %v, want ErrInvalidPatch", err)
+	}
+}
+
+// Most cases are the examples of RFC 6902, appendix A.
+func TestJSONPatch(t *testing.T) {
+	cases := []struct {
+		name            string
+		document, patch string
+		want            string
+		err             error
+	}{
+		{"add a member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{"add an element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"remove a member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"remove an element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"replace a value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{"move a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{"move an element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{"test passes", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"test fails", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrPatchTestFailed},
+		{"add a nested member", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{"ignore unknown members", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`, nil},
+		{"add to a missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", errAny},
+		{"test escaped paths", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{"test compares types", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, "", ErrPatchTestFailed},
+		{"add an array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+		{"copy a value", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`, `{"foo":{"bar":1},"baz":{"bar":2}}`, nil},
+		{"replace the document", `{"foo":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, nil},
+		{"replace a missing member", `{"foo":1}`, `[{"op":"replace","path":"/bar","value":2}]`, "", errAny},
+		{"remove a missing member", `{"foo":1}`, `[{"op":"remove","path":"/bar"}]`, "", errAny},
+		{"remove the document", `{"foo":1}`, `[{"op":"remove","path":""}]`, "", errAny},
+		{"index past the end", `{"foo":[1]}`, `[{"op":"add","path":"/foo/2","value":3}]`, "", errAny},
+		{"index with a leading zero", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/01"}]`, "", errAny},
+		{"remove the end", `{"foo":[1]}`, `[{"op":"remove","path":"/foo/-"}]`, "", errAny},
+		{"move into itself", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, "", errAny},
+		{"no path", `{}`, `[{"op":"add","value":1}]`, "", ErrInvalidPatch},
+		{"no value", `{}`, `[{"op":"add","path":"/foo"}]`, "", ErrInvalidPatch},
+		{"no from", `{}`, `[{"op":"copy","path":"/foo"}]`, "", ErrInvalidPatch},
+		{"unknown operation", `{}`, `[{"op":"merge","path":"/foo","value":1}]`, "", ErrInvalidPatch},
+		{"relative path", `{}`, `[{"op":"add","path":"foo","value":1}]`, "", ErrInvalidPatch},
+		{"not an array", `{}`, `{"op":"add","path":"/foo","value":1}`, "", ErrInvalidPatch},
+		// the failed test undoes the add before it
+		{"all or nothing", `{"foo":1}`, `[{"op":"add","path":"/bar","value":2},{"op":"test","path":"/foo","value":2}]`, "", ErrPatchTestFailed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := JSONPatch([]byte(c.document), []byte(c.patch))
+			if c.err != nil {
+				checkPatchError(t, err, c.err)
+				if got != nil {
+					t.Errorf("the failed patch returned %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("JSONPatch: %v", err)
+			}
+			if !sameJSON(t, got, c.want) {
+				t.Errorf("JSONPatch = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
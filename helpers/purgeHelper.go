@@ -0,0 +1,145 @@
+package helpers
+
+import (
+	"context"
+	"log"
+	"nitiwat/database"
+	"nitiwat/models"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PurgeReport lists what a purge removes: archived users and trashed todos
+// deleted before the cutoff, which is the retention period before the run.
+type PurgeReport struct {
+	Run_at   time.Time            `json:"run_at"`
+	Cutoff   time.Time            `json:"cutoff"`
+	Archives []models.DeleteModal `json:"archives"`
+	Todos    []models.Todo        `json:"todos"`
+}
+
+var purgeMu sync.Mutex
+var nextPurgeAt time.Time
+
+// NextPurgeAt returns when the purge worker runs next, or the current time if
+// it is not running.
+func NextPurgeAt() time.Time {
+	purgeMu.Lock()
+	defer purgeMu.Unlock()
+
+	if nextPurgeAt.IsZero() {
+		return time.Now()
+	}
+	return nextPurgeAt
+}
+
+// PlanPurge reports what a purge running at runAt would remove without
+// removing anything.
+func PlanPurge(ctx context.Context, runAt time.Time) (PurgeReport, error) {
+	cutoff := runAt.Add(-cfg.RetentionPeriod)
+	report := PurgeReport{Run_at: runAt, Cutoff: cutoff}
+
+	archives, err := archiveStore.FindDeletedBefore(ctx, cutoff)
+	if err != nil {
+		return report, err
+	}
+	report.Archives = archives
+
+	todos, err := todoStore.Find(ctx, database.TodoFilter{Trashed: true, Deleted_before: &cutoff})
+	if err != nil {
+		return report, err
+	}
+	report.Todos = todos
+
+	return report, nil
+}
+
+// Purge permanently removes the archived users and trashed todos that have
+// outlived the retention period at runAt, in one transaction. The history of
+// the todos it removes is kept, closed by a delete revision for each.
+func Purge(ctx context.Context, runAt time.Time) (archives int64, todos int64, err error) {
+	cutoff := runAt.Add(-cfg.RetentionPeriod)
+	filter := database.TodoFilter{Trashed: true, Deleted_before: &cutoff}
+
+	err = transactions.Transaction(ctx, func(ctx context.Context) error {
+		expired, err := archiveStore.FindDeletedBefore(ctx, cutoff)
+		if err != nil {
+			return err
+		}
+		trashed, err := todoStore.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		var removed []primitive.ObjectID
+		archives, removed, err = archiveStore.DeleteDeletedBefore(ctx, cutoff)
+		if err != nil {
+			return err
+		}
+		removedTrash, err := todoStore.DeleteMany(ctx, filter)
+		if err != nil {
+			return err
+		}
+		todos = int64(len(removedTrash))
+
+		// only what was still there to remove is recorded: a user or todo
+		// restored since it was found stays
+		gone := map[primitive.ObjectID]bool{}
+		for _, id := range append(removed, removedTrash...) {
+			gone[id] = true
+		}
+		for _, archive := range expired {
+			trashed = append(trashed, archive.Todos...)
+		}
+		for _, todo := range trashed {
+			if !gone[todo.ID] {
+				continue
+			}
+			if err := recordPurge(ctx, todo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return archives, todos, err
+}
+
+// recordPurge records the removal of a todo by the purge as the last
+// revision of its history.
+func recordPurge(ctx context.Context, todo models.Todo) error {
+	return revisionStore.Insert(ctx, models.Revision{
+		ID:         primitive.NewObjectID(),
+		Todo_id:    todo.ID,
+		User_id:    todo.User_id,
+		Actor:      models.SystemActor,
+		Action:     models.RevisionDelete,
+		Changes:    []models.FieldChange{},
+		Snapshot:   todo,
+		Created_at: time.Now(),
+	})
+}
+
+// StartPurgeWorker runs Purge once per interval, starting right away, until
+// the process exits.
+func StartPurgeWorker(interval time.Duration) {
+	go func() {
+		for {
+			var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+			archives, todos, err := Purge(ctx, time.Now())
+			cancel()
+			if err != nil {
+				log.Printf("Error purging expired archives and trash: %v", err)
+			} else if archives > 0 || todos > 0 {
+				log.Printf("Purged %d archived users and %d trashed todos", archives, todos)
+			}
+
+			purgeMu.Lock()
+			nextPurgeAt = time.Now().Add(interval)
+			purgeMu.Unlock()
+
+			time.Sleep(interval)
+		}
+	}()
+}
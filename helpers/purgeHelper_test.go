@@ -0,0 +1,87 @@
+package helpers
+
+import (
+	"context"
+	"nitiwat/config"
+	"nitiwat/database"
+	"nitiwat/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPurgeKeepsHistory(t *testing.T) {
+	conf, err := config.Load([]string{"--store", config.StoreMemory, "--secret-key", "test-secret", "--retention-period", "24h"})
+	if err != nil {
+		t.Fatalf("loading the configuration: %v", err)
+	}
+	stores := database.NewMemoryStores()
+	Setup(conf, stores)
+	ctx := context.Background()
+
+	now := time.Now()
+	old, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	todo := func(title string, deletedAt *time.Time) models.Todo {
+		return models.Todo{ID: primitive.NewObjectID(), User_id: "u1", Title: title, Created_at: old, Deleted_at: deletedAt}
+	}
+	expired, kept, live := todo("expired", &old), todo("kept", &recent), todo("live", nil)
+	for _, todo := range []models.Todo{expired, kept, live} {
+		if err := stores.Todos.Insert(ctx, todo); err != nil {
+			t.Fatalf("inserting %s: %v", todo.Title, err)
+		}
+	}
+	archived := todo("archived", nil)
+	archive := models.DeleteModal{ID: primitive.NewObjectID(), User: models.User{User_id: "u2"}, Todos: []models.Todo{archived}, Deleted_at: old}
+	if err := stores.Archive.Insert(ctx, archive); err != nil {
+		t.Fatalf("inserting the archive: %v", err)
+	}
+	created := models.Revision{ID: primitive.NewObjectID(), Todo_id: expired.ID, User_id: "u1", Action: models.RevisionCreate, Snapshot: expired, Created_at: old}
+	if err := stores.Revisions.Insert(ctx, created); err != nil {
+		t.Fatalf("inserting the revision: %v", err)
+	}
+
+	archives, todos, err := Purge(ctx, now)
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if archives != 1 || todos != 1 {
+		t.Errorf("Purge removed %d archives and %d todos, want 1 and 1", archives, todos)
+	}
+	if _, err := stores.Todos.FindById(ctx, expired.ID); err != database.ErrNotFound {
+		t.Errorf("the expired todo is still there: %v", err)
+	}
+	for _, todo := range []models.Todo{kept, live} {
+		if _, err := stores.Todos.FindById(ctx, todo.ID); err != nil {
+			t.Errorf("the %s todo was removed: %v", todo.Title, err)
+		}
+	}
+
+	cases := []struct {
+		todo    models.Todo
+		actions []string
+	}{
+		{expired, []string{models.RevisionCreate, models.RevisionDelete}},
+		{archived, []string{models.RevisionDelete}},
+		{kept, nil},
+		{live, nil},
+	}
+	for _, c := range cases {
+		revisions, err := stores.Revisions.Find(ctx, c.todo.ID)
+		if err != nil {
+			t.Fatalf("finding the revisions of %s: %v", c.todo.Title, err)
+		}
+		if len(revisions) != len(c.actions) {
+			t.Errorf("%s has %d revisions, want %v", c.todo.Title, len(revisions), c.actions)
+			continue
+		}
+		for i, revision := range revisions {
+			if revision.Action != c.actions[i] {
+				t.Errorf("revision %d of %s is a %s, want %s", i, c.todo.Title, revision.Action, c.actions[i])
+			}
+		}
+		if n := len(revisions); n > 0 && revisions[n-1].Actor != models.SystemActor {
+			t.Errorf("the purge of %s was recorded as by %q", c.todo.Title, revisions[n-1].Actor)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package helpers
+
+import "strings"
+
+// Ranks are strings over rankDigits compared byte by byte, so a rank can
+// always be found between two others without touching any other document.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// rankDigit returns the value of the i-th digit of rank, or missing when the
+// rank is shorter.
+func rankDigit(rank string, i int, missing int) int {
+	if i >= len(rank) {
+		return missing
+	}
+	return strings.IndexByte(rankDigits, rank[i])
+}
+
+// RankBetween returns a rank that sorts after lo and before hi. An empty lo
+// means no lower bound and an empty hi no upper bound; lo must sort before hi.
+func RankBetween(lo string, hi string) string {
+	rank := []byte{}
+	for i := 0; ; i++ {
+		l := rankDigit(lo, i, 0)
+		h := len(rankDigits)
+		if hi != "" {
+			h = rankDigit(hi, i, 0)
+		}
+
+		switch {
+		case l == h:
+			rank = append(rank, rankDigits[l])
+		case h-l > 1:
+			return string(append(rank, rankDigits[(l+h)/2]))
+		default:
+			// No digit fits between l and h: keep l and look for a rank above
+			// the rest of lo, which hi no longer bounds.
+			rank = append(rank, rankDigits[l])
+			if i+1 < len(lo) {
+				return string(rank) + RankBetween(lo[i+1:], "")
+			}
+			return string(rank) + RankBetween("", "")
+		}
+	}
+}
+
+// RankAfter returns a short rank that sorts after rank, for appending. The
+// rank is counted up like a number, dropping the digits after the one that
+// is carried into, so it only grows once every digit is the last one.
+func RankAfter(rank string) string {
+	for i := len(rank) - 1; i >= 0; i-- {
+		digit := strings.IndexByte(rankDigits, rank[i])
+		if digit+1 < len(rankDigits) {
+			return rank[:i] + string(rankDigits[digit+1])
+		}
+	}
+	return rank + RankBetween("", "")
+}
+
+// MaxRankLength is the length past which the ranks of a user's todos are
+// spread out again with SpreadRanks rather than grown further.
+const MaxRankLength = 12
+
+// SpreadRanks returns n ascending ranks of at most the same short length,
+// evenly spaced over the lower half of the ranks, which leaves room between
+// them for moves and above them for appends.
+func SpreadRanks(n int) []string {
+	base := int64(len(rankDigits))
+	width, space := 1, base
+	for space < 4*int64(n+1) {
+		width++
+		space *= base
+	}
+	step := space / 2 / int64(n+1)
+
+	ranks := make([]string, n)
+	for i := range ranks {
+		value := step * int64(i+1)
+		digits := make([]byte, width)
+		for j := width - 1; j >= 0; j-- {
+			digits[j] = rankDigits[value%base]
+			value /= base
+		}
+		// trailing zeros sort the same without, and RankBetween cannot go
+		// below a rank ending in one
+		ranks[i] = strings.TrimRight(string(digits), rankDigits[:1])
+	}
+	return ranks
+}
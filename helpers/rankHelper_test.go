@@ -0,0 +1,82 @@
+package helpers
+
+import "testing"
+
+func TestRankBetween(t *testing.T) {
+	cases := []struct {
+		name   string
+		lo, hi string
+	}{
+		{"unbounded", "", ""},
+		{"below", "", "5"},
+		{"above", "5", ""},
+		{"apart", "1", "9"},
+		{"adjacent digits", "1", "2"},
+		{"prefix", "1", "15"},
+		{"below a rank ending in one", "", "01"},
+		{"last digit", "z", ""},
+		{"after the last digits", "1zz", "2"},
+		{"longer lo", "0h3", "0h4"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rank := RankBetween(c.lo, c.hi)
+			if rank <= c.lo || (c.hi != "" && rank >= c.hi) {
+				t.Errorf("RankBetween(%q, %q) = %q, which is not between them", c.lo, c.hi, rank)
+			}
+			if rank[len(rank)-1] == rankDigits[0] {
+				t.Errorf("RankBetween(%q, %q) = %q, which ends in the lowest digit", c.lo, c.hi, rank)
+			}
+		})
+	}
+}
+
+func TestRankAfter(t *testing.T) {
+	cases := []struct {
+		rank, want string
+	}{
+		{"", "i"},
+		{"1", "2"},
+		{"1z", "2"},
+		{"zz", "zzi"},
+	}
+	for _, c := range cases {
+		if got := RankAfter(c.rank); got != c.want {
+			t.Errorf("RankAfter(%q) = %q, want %q", c.rank, got, c.want)
+		}
+	}
+}
+
+func TestSpreadRanks(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 8, 35, 100, 5000} {
+		ranks := SpreadRanks(n)
+		if len(ranks) != n {
+			t.Fatalf("SpreadRanks(%d) returned %d ranks", n, len(ranks))
+		}
+		for i, rank := range ranks {
+			if rank == "" || len(rank) > MaxRankLength {
+				t.Errorf("SpreadRanks(%d)[%d] = %q", n, i, rank)
+			}
+			if i > 0 && ranks[i-1] >= rank {
+				t.Errorf("SpreadRanks(%d) is not ascending at %d: %q, %q", n, i, ranks[i-1], rank)
+			}
+		}
+		// there is room to append after the last rank without growing it
+		if n > 0 && len(RankAfter(ranks[n-1])) > len(ranks[n-1]) {
+			t.Errorf("SpreadRanks(%d) leaves no room after %q", n, ranks[n-1])
+		}
+	}
+}
+
+// TestRankBetweenRepeatedMoves keeps moving a todo right after the first one,
+// which halves the same gap over and over.
+func TestRankBetweenRepeatedMoves(t *testing.T) {
+	lo, hi := SpreadRanks(2)[0], SpreadRanks(2)[1]
+	for i := 0; i < 200; i++ {
+		rank := RankBetween(lo, hi)
+		if rank <= lo || rank >= hi {
+			t.Fatalf("move %d: RankBetween(%q, %q) = %q", i, lo, hi, rank)
+		}
+		hi = rank
+	}
+}
@@ -0,0 +1,93 @@
+package helpers
+
+import (
+	"errors"
+	"nitiwat/models"
+	"time"
+)
+
+// CheckRecurrence reports the first inconsistency of a recurrence rule the
+// validate tags cannot express, and sets the default interval of one day.
+func CheckRecurrence(rule *models.Recurrence) error {
+	switch rule.Frequency {
+	case models.RepeatDaily, models.RepeatAfterCompletion:
+		if rule.Interval == 0 {
+			rule.Interval = 1
+		}
+	case models.RepeatWeekly:
+		if len(rule.Weekdays) == 0 {
+			return errors.New("a weekly recurrence needs at least one weekday")
+		}
+	case models.RepeatMonthly:
+		if rule.Month_day == 0 {
+			return errors.New("a monthly recurrence needs a month_day")
+		}
+	}
+	return nil
+}
+
+// addDays moves t by n calendar days in loc, keeping its clock time.
+func addDays(t time.Time, n int, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day()+n, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// nextScheduled returns the first time after t the schedule of rule falls on,
+// at the clock time of t.
+func nextScheduled(rule models.Recurrence, t time.Time, loc *time.Location) time.Time {
+	switch rule.Frequency {
+	case models.RepeatWeekly:
+		for n := 1; ; n++ {
+			next := addDays(t, n, loc)
+			for _, weekday := range rule.Weekdays {
+				if int(next.Weekday()) == weekday {
+					return next
+				}
+			}
+		}
+	case models.RepeatMonthly:
+		t = t.In(loc)
+		for n := 0; ; n++ {
+			// the first of the month n months on, then day Month_day of it,
+			// or its last day in shorter months
+			first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+			last := first.AddDate(0, 1, -1).Day()
+			day := rule.Month_day
+			if day > last {
+				day = last
+			}
+			next := first.AddDate(0, 0, day-1)
+			if next.After(t) {
+				return next
+			}
+		}
+	}
+	return addDays(t, rule.Interval, loc)
+}
+
+// NextDue returns the due date of the occurrence that follows one due at due
+// (nil when it had none) and completed at completed. Schedules are followed
+// in loc from the previous due date, skipping occurrences that are already
+// past, so a late completion does not leave the next one overdue.
+func NextDue(rule models.Recurrence, due *time.Time, completed time.Time, loc *time.Location) time.Time {
+	if rule.Frequency == models.RepeatAfterCompletion {
+		if due == nil {
+			return addDays(completed, rule.Interval, loc)
+		}
+		// keep the clock time of the previous due date
+		day := StartOfDay(completed, loc)
+		clock := due.In(loc).Sub(StartOfDay(*due, loc))
+		return addDays(day, rule.Interval, loc).Add(clock)
+	}
+
+	next := completed
+	if due != nil {
+		next = *due
+	}
+	for {
+		next = nextScheduled(rule, next, loc)
+		if next.After(completed) {
+			return next
+		}
+	}
+}
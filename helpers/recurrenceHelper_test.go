@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"nitiwat/models"
+	"testing"
+	"time"
+)
+
+func TestNextDue(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("loading a time zone: %v", err)
+	}
+	bangkok, err := time.LoadLocation("Asia/Bangkok")
+	if err != nil {
+		t.Fatalf("loading a time zone: %v", err)
+	}
+	at := func(s string, loc *time.Location) time.Time {
+		t.Helper()
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", s, err)
+		}
+		return parsed
+	}
+	due := func(s string, loc *time.Location) *time.Time {
+		parsed := at(s, loc)
+		return &parsed
+	}
+
+	daily := models.Recurrence{Frequency: models.RepeatDaily, Interval: 1}
+	everyThreeDays := models.Recurrence{Frequency: models.RepeatDaily, Interval: 3}
+	mondayThursday := models.Recurrence{Frequency: models.RepeatWeekly, Weekdays: []int{1, 4}}
+	monthEnd := models.Recurrence{Frequency: models.RepeatMonthly, Month_day: 31}
+	midMonth := models.Recurrence{Frequency: models.RepeatMonthly, Month_day: 15}
+	twoDaysAfter := models.Recurrence{Frequency: models.RepeatAfterCompletion, Interval: 2}
+
+	cases := []struct {
+		name      string
+		rule      models.Recurrence
+		due       *time.Time
+		completed time.Time
+		loc       *time.Location
+		want      time.Time
+	}{
+		{"daily", daily, due("2026-01-05 09:00", time.UTC), at("2026-01-05 08:00", time.UTC), time.UTC, at("2026-01-06 09:00", time.UTC)},
+		{"daily completed late", daily, due("2026-01-05 09:00", time.UTC), at("2026-01-08 12:00", time.UTC), time.UTC, at("2026-01-09 09:00", time.UTC)},
+		{"every three days without a due date", everyThreeDays, nil, at("2026-01-05 12:00", time.UTC), time.UTC, at("2026-01-08 12:00", time.UTC)},
+		{"daily across daylight saving", daily, due("2026-03-07 09:00", newYork), at("2026-03-07 08:00", newYork), newYork, at("2026-03-08 09:00", newYork)},
+		{"weekday later in the week", mondayThursday, due("2026-01-05 09:00", time.UTC), at("2026-01-05 10:00", time.UTC), time.UTC, at("2026-01-08 09:00", time.UTC)},
+		{"weekday in the next week", mondayThursday, due("2026-01-08 09:00", time.UTC), at("2026-01-08 10:00", time.UTC), time.UTC, at("2026-01-12 09:00", time.UTC)},
+		{"weekday completed a week late", mondayThursday, due("2026-01-05 09:00", time.UTC), at("2026-01-13 10:00", time.UTC), time.UTC, at("2026-01-15 09:00", time.UTC)},
+		{"weekday in the time zone", mondayThursday, due("2026-01-05 07:00", bangkok), at("2026-01-05 01:00", time.UTC), bangkok, at("2026-01-08 07:00", bangkok)},
+		{"month end into February", monthEnd, due("2026-01-31 09:00", time.UTC), at("2026-01-31 10:00", time.UTC), time.UTC, at("2026-02-28 09:00", time.UTC)},
+		{"month end after February", monthEnd, due("2026-02-28 09:00", time.UTC), at("2026-02-28 10:00", time.UTC), time.UTC, at("2026-03-31 09:00", time.UTC)},
+		{"month end in a leap year", monthEnd, due("2028-01-31 09:00", time.UTC), at("2028-01-31 10:00", time.UTC), time.UTC, at("2028-02-29 09:00", time.UTC)},
+		{"month end into a 30 day month", monthEnd, due("2026-03-31 09:00", time.UTC), at("2026-03-31 10:00", time.UTC), time.UTC, at("2026-04-30 09:00", time.UTC)},
+		{"monthly completed late", midMonth, due("2026-01-15 09:00", time.UTC), at("2026-03-20 10:00", time.UTC), time.UTC, at("2026-04-15 09:00", time.UTC)},
+		{"monthly before the day", midMonth, nil, at("2026-01-10 10:00", time.UTC), time.UTC, at("2026-01-15 10:00", time.UTC)},
+		{"after completion keeps the clock time", twoDaysAfter, due("2026-01-05 09:00", time.UTC), at("2026-01-10 18:00", time.UTC), time.UTC, at("2026-01-12 09:00", time.UTC)},
+		{"after completion completed early", twoDaysAfter, due("2026-01-10 09:00", time.UTC), at("2026-01-05 18:00", time.UTC), time.UTC, at("2026-01-07 09:00", time.UTC)},
+		{"after completion without a due date", twoDaysAfter, nil, at("2026-01-10 18:00", time.UTC), time.UTC, at("2026-01-12 18:00", time.UTC)},
+		{"after completion on the local day", twoDaysAfter, due("2026-01-05 09:00", bangkok), at("2026-01-10 23:30", time.UTC), bangkok, at("2026-01-13 09:00", bangkok)},
+		{"after completion across month end", twoDaysAfter, due("2026-01-30 09:00", time.UTC), at("2026-01-30 20:00", time.UTC), time.UTC, at("2026-02-01 09:00", time.UTC)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := NextDue(c.rule, c.due, c.completed, c.loc); !got.Equal(c.want) {
+				t.Errorf("NextDue = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
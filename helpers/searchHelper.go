@@ -0,0 +1,92 @@
+package helpers
+
+import (
+	"html"
+	"nitiwat/database"
+	"strings"
+)
+
+// SnippetLength is about how many bytes of a long text Highlight keeps around
+// the first match.
+const SnippetLength = 160
+
+// Highlight returns the text with the words matching the query wrapped in
+// <mark> tags and everything else HTML-escaped, or "" when nothing in it
+// matches. A text longer than width, when width is not 0, is cut down to a
+// fragment starting shortly before the first match, with an ellipsis where
+// text was left out.
+func Highlight(text string, query database.SearchQuery, width int) string {
+	tokens := database.Tokenize(text)
+	words := make([]string, len(tokens))
+	for i, token := range tokens {
+		words[i] = token.Text
+	}
+	marked := make([]bool, len(tokens))
+	first := -1
+	for i, token := range tokens {
+		for _, word := range query.Words {
+			marked[i] = marked[i] || token.Text == word
+		}
+		for _, prefix := range query.Prefixes {
+			marked[i] = marked[i] || strings.HasPrefix(token.Text, prefix)
+		}
+		for _, phrase := range query.Phrases {
+			if database.PhraseAt(words, i, phrase) {
+				for j := range phrase {
+					marked[i+j] = true
+				}
+			}
+		}
+		if marked[i] && first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	// the fragment runs from a word a third of the width before the first
+	// match to the last word that fits
+	from, to := 0, len(tokens)
+	if width > 0 && len(text) > width {
+		from = first
+		for from > 0 && tokens[first].Start-tokens[from-1].Start <= width/3 {
+			from--
+		}
+		to = first + 1
+		for to < len(tokens) && tokens[to].End-tokens[from].Start <= width {
+			to++
+		}
+	}
+	start, end := 0, len(text)
+	if from > 0 {
+		start = tokens[from].Start
+	}
+	if to < len(tokens) {
+		end = tokens[to-1].End
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	at := start
+	for i := from; i < to; i++ {
+		if !marked[i] || (i > from && marked[i-1]) {
+			continue
+		}
+		// consecutive matched words share one mark
+		last := i
+		for last+1 < to && marked[last+1] {
+			last++
+		}
+		b.WriteString(html.EscapeString(text[at:tokens[i].Start]))
+		b.WriteString("<mark>" + html.EscapeString(text[tokens[i].Start:tokens[last].End]) + "</mark>")
+		at = tokens[last].End
+	}
+	b.WriteString(html.EscapeString(text[at:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"nitiwat/database"
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("lorem ipsum ", 20) + "buy milk today " + strings.Repeat("dolor sit ", 20)
+
+	cases := []struct {
+		name  string
+		text  string
+		query string
+		width int
+		want  string
+	}{
+		{"word", "Buy milk", "milk", 0, "Buy <mark>milk</mark>"},
+		{"case is kept", "MILK and Milk", "milk", 0, "<mark>MILK</mark> and <mark>Milk</mark>"},
+		{"whole words only", "Buttermilk", "milk", 0, ""},
+		{"prefix", "Buy milkshake", "milk*", 0, "Buy <mark>milkshake</mark>"},
+		{"adjacent words share a mark", "Buy oat milk", "oat milk", 0, "Buy <mark>oat milk</mark>"},
+		{"punctuation between words", "Buy oat, milk", "oat milk", 0, "Buy <mark>oat, milk</mark>"},
+		{"phrase", "Buy milk, not milk powder", `"buy milk"`, 0, "<mark>Buy milk</mark>, not milk powder"},
+		{"phrase out of order", "Milk to buy", `"buy milk"`, 0, ""},
+		{"html is escaped", "<b>milk</b> & bread", "milk", 0, "&lt;b&gt;<mark>milk</mark>&lt;/b&gt; &amp; bread"},
+		{"no match", "Walk the dog", "milk", 0, ""},
+		{"short text is not cut", "Buy milk", "milk", 160, "Buy <mark>milk</mark>"},
+		// a third of the width before the match, and the whole words that fit
+		{"fragment", long, "milk", 40, "…ipsum buy <mark>milk</mark> today dolor sit dolor sit…"},
+		{"fragment at the start", "milk " + long, "milk", 20, "<mark>milk</mark> lorem ipsum…"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Highlight(c.text, database.ParseSearch(c.query), c.width); got != c.want {
+				t.Errorf("Highlight = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"nitiwat/models"
+	"sort"
+	"time"
+)
+
+// MaxStatsDays bounds the number of days a statistics range may cover.
+const MaxStatsDays = 366
+
+// StatsBucket counts the todos created and completed in one day or week. Date
+// is the first day of the bucket; weeks start on Monday.
+type StatsBucket struct {
+	Date      string `json:"date"`
+	Created   int    `json:"created"`
+	Completed int    `json:"completed"`
+}
+
+// TodoStats sums up a user's todos. Open, Done, Completion_rate,
+// Average_completion_seconds and Current_streak cover all of them; Daily and
+// Weekly only the days from From to To.
+type TodoStats struct {
+	Open            int     `json:"open"`
+	Done            int     `json:"done"`
+	Completion_rate float64 `json:"completion_rate"`
+	// Average_completion_seconds is the mean time from creation to
+	// completion of the done todos, or null when none has been completed.
+	Average_completion_seconds *float64 `json:"average_completion_seconds"`
+	// Current_streak is the number of days in a row, up to today, with a
+	// todo completed. A streak still counts when nothing has been completed
+	// today yet.
+	Current_streak int           `json:"current_streak"`
+	From           string        `json:"from"`
+	To             string        `json:"to"`
+	Timezone       string        `json:"timezone"`
+	Daily          []StatsBucket `json:"daily"`
+	Weekly         []StatsBucket `json:"weekly"`
+}
+
+const statsDateLayout = "2006-01-02"
+
+// StatsRange reads the from and to dates of a statistics request, given as
+// YYYY-MM-DD in loc. Both days are included; the range defaults to the 30
+// days ending today.
+func StatsRange(from string, to string, loc *time.Location) (start time.Time, end time.Time, err error) {
+	end = StartOfDay(time.Now(), loc)
+	if to != "" {
+		if end, err = time.ParseInLocation(statsDateLayout, to, loc); err != nil {
+			return start, end, errors.New("to must be a date like 2006-01-02")
+		}
+	}
+	start = end.AddDate(0, 0, -29)
+	if from != "" {
+		if start, err = time.ParseInLocation(statsDateLayout, from, loc); err != nil {
+			return start, end, errors.New("from must be a date like 2006-01-02")
+		}
+	}
+
+	if start.After(end) {
+		return start, end, errors.New("from must not be after to")
+	}
+	if start.AddDate(0, 0, MaxStatsDays-1).Before(end) {
+		return start, end, fmt.Errorf("the range must not be longer than %d days", MaxStatsDays)
+	}
+	return start, end, nil
+}
+
+// startOfWeek returns midnight at the start of the Monday of day's week.
+func startOfWeek(day time.Time) time.Time {
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// TodoStatsCounter sums up todos one at a time, so they can be streamed from
+// the store rather than loaded at once.
+type TodoStatsCounter struct {
+	stats         TodoStats
+	loc           *time.Location
+	days          map[string]*StatsBucket
+	weeks         map[string]*StatsBucket
+	completedDays map[string]bool
+	total         time.Duration
+	completed     int
+}
+
+// NewTodoStatsCounter returns a counter bucketing the days from start to end
+// in loc. Start and end must be midnights in loc.
+func NewTodoStatsCounter(start time.Time, end time.Time, loc *time.Location) *TodoStatsCounter {
+	s := &TodoStatsCounter{
+		stats: TodoStats{
+			From:     start.Format(statsDateLayout),
+			To:       end.Format(statsDateLayout),
+			Timezone: loc.String(),
+			Daily:    []StatsBucket{},
+			Weekly:   []StatsBucket{},
+		},
+		loc: loc,
+		// days are keyed by their date, which also works across DST changes
+		days:          map[string]*StatsBucket{},
+		weeks:         map[string]*StatsBucket{},
+		completedDays: map[string]bool{},
+	}
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		s.stats.Daily = append(s.stats.Daily, StatsBucket{Date: day.Format(statsDateLayout)})
+		week := startOfWeek(day).Format(statsDateLayout)
+		if len(s.stats.Weekly) == 0 || s.stats.Weekly[len(s.stats.Weekly)-1].Date != week {
+			s.stats.Weekly = append(s.stats.Weekly, StatsBucket{Date: week})
+		}
+	}
+	for i := range s.stats.Daily {
+		s.days[s.stats.Daily[i].Date] = &s.stats.Daily[i]
+	}
+	for i := range s.stats.Weekly {
+		s.weeks[s.stats.Weekly[i].Date] = &s.stats.Weekly[i]
+	}
+	return s
+}
+
+// bucket returns the day and week buckets of t, or nils outside the range.
+func (s *TodoStatsCounter) bucket(t time.Time) (*StatsBucket, *StatsBucket) {
+	day := StartOfDay(t, s.loc)
+	return s.days[day.Format(statsDateLayout)], s.weeks[startOfWeek(day).Format(statsDateLayout)]
+}
+
+// Add counts a todo.
+func (s *TodoStatsCounter) Add(todo models.Todo) {
+	if day, week := s.bucket(todo.Created_at); day != nil {
+		day.Created++
+		week.Created++
+	}
+	if !todo.Check {
+		s.stats.Open++
+		return
+	}
+	s.stats.Done++
+	// todos checked before completion times were kept have none
+	if todo.Completed_at == nil {
+		return
+	}
+	if day, week := s.bucket(*todo.Completed_at); day != nil {
+		day.Completed++
+		week.Completed++
+	}
+	s.completedDays[StartOfDay(*todo.Completed_at, s.loc).Format(statsDateLayout)] = true
+	if taken := todo.Completed_at.Sub(todo.Created_at); taken > 0 {
+		s.total += taken
+	}
+	s.completed++
+}
+
+// Stats returns the statistics of the todos added so far, as of now.
+func (s *TodoStatsCounter) Stats(now time.Time) TodoStats {
+	stats := s.stats
+	if count := stats.Open + stats.Done; count > 0 {
+		stats.Completion_rate = float64(stats.Done) / float64(count)
+	}
+	if s.completed > 0 {
+		average := s.total.Seconds() / float64(s.completed)
+		stats.Average_completion_seconds = &average
+	}
+
+	day := StartOfDay(now, s.loc)
+	if !s.completedDays[day.Format(statsDateLayout)] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for s.completedDays[day.Format(statsDateLayout)] {
+		stats.Current_streak++
+		day = day.AddDate(0, 0, -1)
+	}
+	return stats
+}
+
+// TodoDistribution describes how many live todos users have. Buckets counts
+// the users whose number of todos is at least the From of the bucket and, for
+// all but the last bucket, at most its To.
+type TodoDistribution struct {
+	Users   int64                `json:"users"`
+	Min     int64                `json:"min"`
+	Max     int64                `json:"max"`
+	Mean    float64              `json:"mean"`
+	Median  int64                `json:"median"`
+	P90     int64                `json:"p90"`
+	Buckets []DistributionBucket `json:"buckets"`
+}
+
+// DistributionBucket counts the users with From to To todos; To is null for
+// the last, open ended bucket.
+type DistributionBucket struct {
+	From  int64  `json:"from"`
+	To    *int64 `json:"to"`
+	Users int64  `json:"users"`
+}
+
+// distributionBounds are the lowest todo counts of the distribution buckets.
+var distributionBounds = []int64{0, 1, 6, 21, 51, 101}
+
+// DistributeTodos describes the todo counts of users users, given the counts
+// of those who have any; the others have none.
+func DistributeTodos(counts map[string]int64, users int64) TodoDistribution {
+	values := make([]int64, 0, users)
+	for _, count := range counts {
+		values = append(values, count)
+	}
+	// todos may outlive their user briefly while an account is deleted
+	for int64(len(values)) < users {
+		values = append(values, 0)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	distribution := TodoDistribution{Users: int64(len(values)), Buckets: []DistributionBucket{}}
+	for i, from := range distributionBounds {
+		bucket := DistributionBucket{From: from}
+		if i+1 < len(distributionBounds) {
+			to := distributionBounds[i+1] - 1
+			bucket.To = &to
+		}
+		distribution.Buckets = append(distribution.Buckets, bucket)
+	}
+	if len(values) == 0 {
+		return distribution
+	}
+
+	var total int64
+	for _, value := range values {
+		total += value
+		i := sort.Search(len(distributionBounds), func(i int) bool { return distributionBounds[i] > value }) - 1
+		distribution.Buckets[i].Users++
+	}
+	distribution.Min = values[0]
+	distribution.Max = values[len(values)-1]
+	distribution.Mean = float64(total) / float64(len(values))
+	distribution.Median = values[len(values)/2]
+	distribution.P90 = values[len(values)*9/10]
+	return distribution
+}
+
+// DayCount is a number of something that happened on one day.
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// DailyCounts lists the counts, keyed by date, of the days from start to end,
+// filling in the days without any.
+func DailyCounts(counts map[string]int64, start time.Time, end time.Time) []DayCount {
+	days := []DayCount{}
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(statsDateLayout)
+		days = append(days, DayCount{Date: date, Count: counts[date]})
+	}
+	return days
+}
@@ -0,0 +1,160 @@
+package helpers
+
+import (
+	"nitiwat/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTodoStatsCounter(t *testing.T) {
+	// daylight saving time starts in New York on 2026-03-08
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("loading a time zone: %v", err)
+	}
+	at := func(s string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", s, err)
+		}
+		return parsed
+	}
+	completed := func(s string) *time.Time {
+		t := at(s)
+		return &t
+	}
+
+	start, end := at("2026-03-02 00:00"), at("2026-03-10 00:00")
+	counter := NewTodoStatsCounter(start, end, loc)
+	todos := []models.Todo{
+		{Created_at: at("2026-03-02 10:00")},
+		// created late in the evening, which is the next day in UTC
+		{Created_at: at("2026-03-03 23:30"), Check: true, Completed_at: completed("2026-03-09 00:30")},
+		// created before the range
+		{Created_at: at("2026-02-20 09:00"), Check: true, Completed_at: completed("2026-03-10 08:00")},
+		{Created_at: at("2026-03-08 09:00"), Check: true, Completed_at: completed("2026-03-08 12:00")},
+		// checked before completion times were kept
+		{Created_at: at("2026-02-01 09:00"), Check: true},
+	}
+	for _, todo := range todos {
+		counter.Add(todo)
+	}
+
+	day := func(date string, created int, completed int) StatsBucket {
+		return StatsBucket{Date: date, Created: created, Completed: completed}
+	}
+	wantDaily := []StatsBucket{
+		day("2026-03-02", 1, 0), day("2026-03-03", 1, 0), day("2026-03-04", 0, 0), day("2026-03-05", 0, 0),
+		day("2026-03-06", 0, 0), day("2026-03-07", 0, 0), day("2026-03-08", 1, 1), day("2026-03-09", 0, 1),
+		day("2026-03-10", 0, 1),
+	}
+	wantWeekly := []StatsBucket{day("2026-03-02", 3, 1), day("2026-03-09", 0, 2)}
+	// five days, 17 days and 22 hours, and three hours
+	wantAverage := float64(432000+1548000+10800) / 3
+
+	cases := []struct {
+		name   string
+		now    time.Time
+		streak int
+	}{
+		{"completed today", at("2026-03-10 20:00"), 3},
+		{"nothing completed today yet", at("2026-03-11 10:00"), 3},
+		{"nothing completed yesterday", at("2026-03-12 10:00"), 0},
+		{"after a gap", at("2026-03-09 10:00"), 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stats := counter.Stats(c.now)
+			if stats.Open != 1 || stats.Done != 4 || stats.Completion_rate != 0.8 {
+				t.Errorf("open %d, done %d and rate %v, want 1, 4 and 0.8", stats.Open, stats.Done, stats.Completion_rate)
+			}
+			if stats.Average_completion_seconds == nil || *stats.Average_completion_seconds != wantAverage {
+				t.Errorf("the average completion is %v seconds, want %v", stats.Average_completion_seconds, wantAverage)
+			}
+			if stats.Current_streak != c.streak {
+				t.Errorf("the streak is %d days, want %d", stats.Current_streak, c.streak)
+			}
+			if !reflect.DeepEqual(stats.Daily, wantDaily) {
+				t.Errorf("the days are %+v, want %+v", stats.Daily, wantDaily)
+			}
+			if !reflect.DeepEqual(stats.Weekly, wantWeekly) {
+				t.Errorf("the weeks are %+v, want %+v", stats.Weekly, wantWeekly)
+			}
+			if stats.From != "2026-03-02" || stats.To != "2026-03-10" || stats.Timezone != "America/New_York" {
+				t.Errorf("the range is %s to %s in %s", stats.From, stats.To, stats.Timezone)
+			}
+		})
+	}
+}
+
+func TestTodoStatsCounterEmpty(t *testing.T) {
+	start := time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)
+	stats := NewTodoStatsCounter(start, start, time.UTC).Stats(start)
+
+	want := TodoStats{
+		From:     "2026-03-04",
+		To:       "2026-03-04",
+		Timezone: "UTC",
+		Daily:    []StatsBucket{{Date: "2026-03-04"}},
+		// the week of a Wednesday starts on the Monday before the range
+		Weekly: []StatsBucket{{Date: "2026-03-02"}},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("the stats of no todos are %+v, want %+v", stats, want)
+	}
+}
+
+func TestStatsRange(t *testing.T) {
+	today := StartOfDay(time.Now(), time.UTC)
+	date := func(s string) time.Time {
+		parsed, _ := time.ParseInLocation(statsDateLayout, s, time.UTC)
+		return parsed
+	}
+
+	cases := []struct {
+		name       string
+		from, to   string
+		start, end time.Time
+		fails      bool
+	}{
+		{"default", "", "", today.AddDate(0, 0, -29), today, false},
+		{"to only", "", "2026-03-31", date("2026-03-02"), date("2026-03-31"), false},
+		{"one day", "2026-03-04", "2026-03-04", date("2026-03-04"), date("2026-03-04"), false},
+		{"longest", "2026-01-01", "2027-01-01", date("2026-01-01"), date("2027-01-01"), false},
+		{"too long", "2026-01-01", "2027-01-02", time.Time{}, time.Time{}, true},
+		{"backwards", "2026-03-05", "2026-03-04", time.Time{}, time.Time{}, true},
+		{"bad from", "03/04/2026", "2026-03-04", time.Time{}, time.Time{}, true},
+		{"bad to", "", "tomorrow", time.Time{}, time.Time{}, true},
+	}
+	for _, c := range cases {
+		start, end, err := StatsRange(c.from, c.to, time.UTC)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%s: StatsRange accepted %s to %s", c.name, start, end)
+			}
+			continue
+		}
+		if err != nil || !start.Equal(c.start) || !end.Equal(c.end) {
+			t.Errorf("%s: StatsRange = %s, %s, %v, want %s, %s", c.name, start, end, err, c.start, c.end)
+		}
+	}
+}
+
+func TestDistributeTodos(t *testing.T) {
+	distribution := DistributeTodos(map[string]int64{"u1": 3, "u2": 30, "u3": 1}, 5)
+	if distribution.Users != 5 || distribution.Min != 0 || distribution.Max != 30 || distribution.Mean != 6.8 ||
+		distribution.Median != 1 || distribution.P90 != 30 {
+		t.Errorf("the distribution is %+v", distribution)
+	}
+	users := []int64{}
+	for _, bucket := range distribution.Buckets {
+		users = append(users, bucket.Users)
+	}
+	if want := []int64{2, 2, 0, 1, 0, 0}; !reflect.DeepEqual(users, want) {
+		t.Errorf("the buckets hold %v users, want %v", users, want)
+	}
+	if last := distribution.Buckets[len(distribution.Buckets)-1]; last.From != 101 || last.To != nil {
+		t.Errorf("the last bucket is %+v, want 101 and up", last)
+	}
+}
@@ -2,17 +2,22 @@ package helpers
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"nitiwat/config"
 	"nitiwat/database"
-	"os"
+	"nitiwat/models"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
 )
 
 type SignedDetails struct {
@@ -21,46 +26,99 @@ type SignedDetails struct {
 	Last_name  string `json:"last_name"`
 	Uid        string `json:"uid"`
 	User_type  string `json:"user_type"`
+	Token_type string `json:"token_type"`
+	Family     string `json:"family"`
 	jwt.StandardClaims
 }
 
-var userCollections *mongo.Collection = database.OpenCollection(database.Client, "users")
+var userStore database.UserStore
+var todoStore database.TodoStore
+var archiveStore database.ArchiveStore
+var revocationStore database.RevocationStore
+var revisionStore database.RevisionStore
+var idempotencyStore database.IdempotencyStore
+var transactions database.Transactor
+
+var SECRET_KEY string
+
+var cfg *config.Config
+
+// Setup hands the helpers the configuration and the stores they work on. It
+// must be called before any token is generated.
+func Setup(config *config.Config, stores database.Stores) {
+	cfg = config
+	userStore = stores.Users
+	todoStore = stores.Todos
+	archiveStore = stores.Archive
+	revocationStore = stores.Revocations
+	revisionStore = stores.Revisions
+	idempotencyStore = stores.Idempotency
+	transactions = stores.Transactions
+	SECRET_KEY = config.SecretKey
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
+	}
+	return hex.EncodeToString(b)
+}
 
-var SECRET_KEY string = os.Getenv("SECRET_KEY")
+// NewTokenFamily returns the id of a new refresh token family. A family starts
+// at login and every token pair issued by refreshing it shares the id.
+func NewTokenFamily() string {
+	return randomID()
+}
 
-func GenerateAllTokens(email string, firstName string, lastName string, userType string, uid string) (signedToken string, signRefreshToken string, err error) {
+func GenerateAllTokens(email string, firstName string, lastName string, userType string, uid string, family string) (signedToken string, signRefreshToken string, err error) {
+	now := time.Now().Local()
 	claims := &SignedDetails{
 		Email:      email,
 		First_name: firstName,
 		Last_name:  lastName,
 		Uid:        uid,
 		User_type:  userType,
+		Token_type: AccessToken,
+		Family:     family,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			Id:        randomID(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(cfg.AccessTokenTTL).Unix(),
 		},
 	}
 
 	refreshClaims := &SignedDetails{
+		Uid:        uid,
+		Token_type: RefreshToken,
+		Family:     family,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			Id:        randomID(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(cfg.RefreshTokenTTL).Unix(),
 		},
 	}
 
 	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		log.Panic(err)
+	}
 
 	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
-
 	if err != nil {
 		log.Panic(err)
 	}
 	return token, refreshToken, err
 }
 
-func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+func parseToken(signedToken string, tokenType string) (claims *SignedDetails, msg string) {
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&SignedDetails{},
 		func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
 			return []byte(SECRET_KEY), nil
 		},
 	)
@@ -71,47 +129,239 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	}
 
 	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
-		msg = fmt.Sprintf("The token is invalid")
-		msg = err.Error()
-		return
+	if !ok || claims.Token_type != tokenType {
+		msg = "The token is invalid"
+		return nil, msg
 	}
 
 	if claims.ExpiresAt < time.Now().Local().Unix() {
-		msg = fmt.Sprintf("The token has expired")
-		msg = err.Error()
-		return
+		msg = "The token has expired"
+		return nil, msg
 
 	}
-	return claims, msg	
+	return claims, msg
+
+}
 
+// ValidateToken checks an access token and returns its claims. Refresh tokens
+// are rejected.
+func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+	return parseToken(signedToken, AccessToken)
+}
 
+// ValidateRefreshToken checks a refresh token and returns its claims. It does
+// not check whether the token is still the current one of its family.
+func ValidateRefreshToken(signedToken string) (claims *SignedDetails, msg string) {
+	return parseToken(signedToken, RefreshToken)
 }
 
+// ErrSessionRevoked and ErrRefreshTokenReused are returned by RotateTokens
+// when the refresh token presented no longer has a session, or has already
+// been exchanged.
+var (
+	ErrSessionRevoked     = errors.New("The refresh token has been revoked")
+	ErrRefreshTokenReused = errors.New("The refresh token was already used")
+)
+
+// UpdateAllTokens stores a freshly generated token pair on the user and makes
+// the refresh token the current one of its family, dropping expired families.
 func UpdateAllTokens(signedToken string, signedRefreshToken string, userId string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	if err := saveTokens(signedToken, signedRefreshToken, userId, ""); err != nil {
+		log.Panic(err)
+	}
+}
+
+// RotateTokens stores the token pair issued in exchange for the presented
+// refresh token like UpdateAllTokens, provided the presented token is still
+// the current one of its family. Checking and saving are one versioned update
+// of the user, so when the same token is exchanged twice at once only one of
+// the exchanges succeeds and the other gets ErrRefreshTokenReused.
+func RotateTokens(signedToken string, signedRefreshToken string, userId string, presented string) error {
+	return saveTokens(signedToken, signedRefreshToken, userId, presented)
+}
 
-	var updateObj primitive.D
+// saveTokens stores a token pair on the user. When presented is set, the
+// family's current refresh token must be presented.
+func saveTokens(signedToken string, signedRefreshToken string, userId string, presented string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	claims, msg := ValidateToken(signedToken)
+	if msg != "" {
+		return errors.New(msg)
+	}
+	refreshClaims, msg := ValidateRefreshToken(signedRefreshToken)
+	if msg != "" {
+		return errors.New(msg)
+	}
 
-	updateObj = append(updateObj, bson.E{"token", signedToken})
-	updateObj = append(updateObj, bson.E{"refresh_token", signedRefreshToken})
+	return updateUser(ctx, userId, func(user *models.User) error {
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
-	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		sessions := []models.Session{}
+		current := models.Session{
+			Family:     refreshClaims.Family,
+			Created_at: Updated_at,
+		}
+		found := false
+		for _, session := range user.Sessions {
+			if session.Family == refreshClaims.Family {
+				if presented != "" && session.Refresh_token != presented {
+					return ErrRefreshTokenReused
+				}
+				found = true
+				current.Created_at = session.Created_at
+				current.Access_tokens = unexpiredTokens(session.Access_tokens, Updated_at)
+				continue
+			}
+			if session.Expires_at.After(Updated_at) {
+				session.Access_tokens = unexpiredTokens(session.Access_tokens, Updated_at)
+				sessions = append(sessions, session)
+			}
+		}
+		if presented != "" && !found {
+			return ErrSessionRevoked
+		}
 
-	updateObj = append(updateObj, bson.E{"updated_at", Updated_at})
+		user.Token = &signedToken
+		user.Refresh_token = &signedRefreshToken
+		user.Updated_at = Updated_at
+		current.Access_tokens = append(current.Access_tokens, models.IssuedToken{
+			Token_id:   claims.Id,
+			Expires_at: time.Unix(claims.ExpiresAt, 0).UTC(),
+		})
+		current.Refresh_token = signedRefreshToken
+		current.Expires_at = time.Unix(refreshClaims.ExpiresAt, 0).UTC()
+		current.Updated_at = Updated_at
+		user.Sessions = append(sessions, current)
+		return nil
+	})
+}
+
+// updateAttempts bounds how often updateUser reloads a user that keeps being
+// saved by concurrent requests.
+const updateAttempts = 5
+
+// updateUser applies change to the stored user and saves it at its next
+// version, starting over from a fresh copy when another request, such as a
+// concurrent login, saved the user in between.
+func updateUser(ctx context.Context, userId string, change func(user *models.User) error) error {
+	for attempt := 1; ; attempt++ {
+		user, err := userStore.FindById(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if err := change(&user); err != nil {
+			return err
+		}
+		user.Version++
+		err = userStore.Update(ctx, user)
+		if err != database.ErrVersionConflict || attempt == updateAttempts {
+			return err
+		}
+	}
+}
 
-	upsert := true
-	filter := bson.M{"user_id": userId}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
+func unexpiredTokens(tokens []models.IssuedToken, now time.Time) []models.IssuedToken {
+	kept := []models.IssuedToken{}
+	for _, token := range tokens {
+		if token.Expires_at.After(now) {
+			kept = append(kept, token)
+		}
 	}
+	return kept
+}
 
-	_, err := userCollections.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &opt)
+// RevokeToken puts a single access token on the revocation list until it
+// expires.
+func RevokeToken(tokenId string, expiresAt time.Time) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
 	defer cancel()
 
-	if err != nil {
-		log.Panic(err)
-		return
+	return revocationStore.Revoke(ctx, models.RevokedToken{Token_id: tokenId, Expires_at: expiresAt})
+}
+
+// IsTokenRevoked reports whether the access token with the given jti has been
+// revoked by a logout or a detected refresh token reuse.
+func IsTokenRevoked(tokenId string) (bool, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	return revocationStore.IsRevoked(ctx, tokenId)
+}
+
+// RevokeTokenFamily ends the session that issued the family: its refresh
+// token can no longer be exchanged and its access tokens are revoked.
+func RevokeTokenFamily(userId string, family string) error {
+	return revokeSessions(userId, func(session models.Session) bool {
+		return session.Family == family
+	})
+}
+
+// RevokeAllTokens ends every session of the user.
+func RevokeAllTokens(userId string) error {
+	return revokeSessions(userId, func(models.Session) bool { return true })
+}
+
+func revokeSessions(userId string, match func(models.Session) bool) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	return updateUser(ctx, userId, func(user *models.User) error {
+		return endSessions(ctx, user, match)
+	})
+}
+
+// RevokeUserTokens ends every session of a user about to be deleted. It only
+// changes the given copy, which is the one to archive: the access tokens are
+// revoked and, with the sessions gone, no refresh token of the account works
+// again, even once it is restored.
+func RevokeUserTokens(ctx context.Context, user *models.User) error {
+	return endSessions(ctx, user, func(models.Session) bool { return true })
+}
+
+// endSessions revokes the access tokens of the sessions of user that match
+// and drops those sessions from it.
+func endSessions(ctx context.Context, user *models.User, match func(models.Session) bool) error {
+	now := time.Now()
+	sessions := []models.Session{}
+	for _, session := range user.Sessions {
+		if !match(session) {
+			sessions = append(sessions, session)
+			continue
+		}
+		for _, token := range unexpiredTokens(session.Access_tokens, now) {
+			err := revocationStore.Revoke(ctx, models.RevokedToken{Token_id: token.Token_id, Expires_at: token.Expires_at})
+			if err != nil {
+				return err
+			}
+		}
+		if user.Refresh_token != nil && *user.Refresh_token == session.Refresh_token {
+			user.Refresh_token = nil
+			user.Token = nil
+		}
 	}
-	return
+	user.Sessions = sessions
+	return nil
+}
+
+// StartRevocationCleanup drops revocation entries for tokens that have expired
+// anyway, and expired idempotency records, once per interval, until the
+// process exits.
+func StartRevocationCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			var ctx, cancel = context.WithTimeout(context.Background(), cfg.RequestTimeout)
+			if _, err := revocationStore.DeleteExpired(ctx, time.Now()); err != nil {
+				log.Printf("Error cleaning up revoked tokens: %v", err)
+			}
+			if _, err := idempotencyStore.DeleteExpired(ctx, time.Now()); err != nil {
+				log.Printf("Error cleaning up idempotency records: %v", err)
+			}
+			cancel()
+		}
+	}()
 }
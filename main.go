@@ -1,6 +1,12 @@
 package main
 
 import (
+	"log"
+	"nitiwat/config"
+	controllers "nitiwat/controllers"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"nitiwat/middleware"
 	routes "nitiwat/routes"
 	"os"
 
@@ -8,19 +14,32 @@ import (
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	stores, err := database.NewStores(cfg)
+	if err != nil {
+		log.Fatalf("Error opening the %s store: %v", cfg.Store, err)
 	}
+	controllers.Setup(cfg, stores)
+	helper.Setup(cfg, stores)
+	helper.StartRevocationCleanup(cfg.RevocationCleanupInterval)
+	helper.StartPurgeWorker(cfg.PurgeInterval)
 
 	router := gin.New()
 	router.Use()
 
 	routes.AuthRouter(router)
-	routes.UserRouter(router)
-	routes.TodoRouter(router)
-	routes.DeletedRouter(router)
 
-	router.Run(":" + port)
+	authenticated := router.Group("/", middleware.Authenticate())
+	routes.UserRouter(authenticated)
+	routes.TodoRouter(authenticated)
+	routes.DeletedRouter(authenticated)
+	routes.TagRouter(authenticated)
+	routes.ListRouter(authenticated)
+	routes.AdminRouter(authenticated)
+
+	router.Run(":" + cfg.Port)
 }
@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	helper "nitiwat/helpers"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,11 +25,26 @@ func Authenticate() gin.HandlerFunc {
 			return
 		}
 
+		revoked, revokedErr := helper.IsTokenRevoked(claims.Id)
+		if revokedErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking the token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "The token has been revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("email", claims.Email)
 		c.Set("first_name", claims.First_name)
 		c.Set("last_name", claims.Last_name)
 		c.Set("uid", claims.Uid)
 		c.Set("user_type", claims.User_type)
+		c.Set("token_id", claims.Id)
+		c.Set("token_family", claims.Family)
+		c.Set("token_expires_at", time.Unix(claims.ExpiresAt, 0))
 		c.Next()
 
 	}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	helper "nitiwat/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// recordingWriter keeps a copy of the response body written through it.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotent makes a request sent with an Idempotency-Key header safe to
+// retry: the response to the first request with the key is stored for the
+// user, or the client address before logging in, and replayed for later ones
+// with the same method, path and body.
+// Reusing the key for a different request is refused, and so is a retry
+// while the first request is still running. Responses with a 5xx status, and
+// requests whose handler panicked, are not kept, so a retry after one is
+// handled again.
+func Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := helper.IdempotencyScope(c.GetString("uid"), c.ClientIP())
+		fingerprint := helper.RequestFingerprint(c.Request.Method, c.FullPath(), body)
+		record, err := helper.BeginIdempotentRequest(scope, key, fingerprint)
+		switch err {
+		case nil:
+		case helper.ErrIdempotencyKeyReused:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		case helper.ErrIdempotencyInProgress:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking the Idempotency-Key"})
+			c.Abort()
+			return
+		}
+		if record != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.Status, record.Content_type, record.Body)
+			c.Abort()
+			return
+		}
+
+		// the key is released in a defer, so a handler that panics does not
+		// leave it reserved until it expires
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := helper.AbandonIdempotentRequest(scope, key, fingerprint); err != nil {
+				log.Printf("Error releasing Idempotency-Key %q: %v", key, err)
+			}
+		}()
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		completed = true
+		err = helper.FinishIdempotentRequest(scope, key, fingerprint, status, writer.Header().Get("Content-Type"), writer.body.Bytes())
+		if err != nil {
+			log.Printf("Error storing the response for Idempotency-Key %q: %v", key, err)
+		}
+	}
+}
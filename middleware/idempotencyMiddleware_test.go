@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"nitiwat/config"
+	"nitiwat/database"
+	helper "nitiwat/helpers"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotentServer serves POST /things behind Idempotent, as the user named
+// by the uid header, if any. The handler counts its calls and answers with
+// the count, unless the test overrides it.
+type idempotentServer struct {
+	router  *gin.Engine
+	mu      sync.Mutex
+	calls   int
+	handler func(c *gin.Context, call int)
+}
+
+func newIdempotentServer(t *testing.T) *idempotentServer {
+	cfg, err := config.Load([]string{"--store", config.StoreMemory, "--secret-key", "test-secret"})
+	if err != nil {
+		t.Fatalf("loading the configuration: %v", err)
+	}
+	helper.Setup(cfg, database.NewMemoryStores())
+
+	gin.SetMode(gin.TestMode)
+	s := &idempotentServer{router: gin.New()}
+	s.router.Use(gin.CustomRecovery(func(c *gin.Context, err interface{}) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	s.router.POST("/things", func(c *gin.Context) {
+		if uid := c.GetHeader("uid"); uid != "" {
+			c.Set("uid", uid)
+		}
+	}, Idempotent(), func(c *gin.Context) {
+		s.mu.Lock()
+		s.calls++
+		call := s.calls
+		s.mu.Unlock()
+		if s.handler != nil {
+			s.handler(c, call)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"call": call})
+	})
+	return s
+}
+
+// post sends a request from the client at addr, as uid when it is set.
+func (s *idempotentServer) post(key string, uid string, addr string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(body))
+	request.RemoteAddr = addr + ":40000"
+	request.Header.Set("Idempotency-Key", key)
+	if uid != "" {
+		request.Header.Set("uid", uid)
+	}
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// idempotentRequest is a request of a sequence sent to one server, with the
+// status and handler call it should be answered with; a call of 0 means the
+// handler is not run.
+type idempotentRequest struct {
+	key, uid, addr, body string
+	status               int
+	call                 int
+}
+
+func TestIdempotentSequences(t *testing.T) {
+	cases := []struct {
+		name     string
+		requests []idempotentRequest
+	}{
+		{"retry is replayed", []idempotentRequest{
+			{"k1", "u1", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+			{"k1", "u1", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+			// the address does not matter once logged in
+			{"k1", "u1", "10.0.0.2", `{"a":1}`, http.StatusCreated, 1},
+		}},
+		{"reused key is refused", []idempotentRequest{
+			{"k1", "u1", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+			{"k1", "u1", "10.0.0.1", `{"a":2}`, http.StatusUnprocessableEntity, 0},
+		}},
+		{"keys are per user", []idempotentRequest{
+			{"k1", "u1", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+			{"k1", "u2", "10.0.0.1", `{"a":2}`, http.StatusCreated, 2},
+			{"k1", "u2", "10.0.0.1", `{"a":2}`, http.StatusCreated, 2},
+		}},
+		{"anonymous retry is replayed", []idempotentRequest{
+			{"k1", "", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+			{"k1", "", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+		}},
+		{"anonymous reused key is refused", []idempotentRequest{
+			{"k1", "", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+			{"k1", "", "10.0.0.1", `{"a":2}`, http.StatusUnprocessableEntity, 0},
+		}},
+		{"anonymous keys are per client", []idempotentRequest{
+			{"k1", "", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+			{"k1", "", "10.0.0.2", `{"a":2}`, http.StatusCreated, 2},
+			{"k1", "u1", "10.0.0.1", `{"a":3}`, http.StatusCreated, 3},
+		}},
+		{"no key is never replayed", []idempotentRequest{
+			{"", "u1", "10.0.0.1", `{"a":1}`, http.StatusCreated, 1},
+			{"", "u1", "10.0.0.1", `{"a":1}`, http.StatusCreated, 2},
+		}},
+		{"overlong key is refused", []idempotentRequest{
+			{strings.Repeat("k", 256), "u1", "10.0.0.1", `{"a":1}`, http.StatusBadRequest, 0},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newIdempotentServer(t)
+			for i, r := range c.requests {
+				response := s.post(r.key, r.uid, r.addr, r.body)
+				if response.Code != r.status {
+					t.Fatalf("request %d answered %d, want %d: %s", i, response.Code, r.status, response.Body)
+				}
+				if r.call > 0 && !strings.Contains(response.Body.String(), `"call":`+strconv.Itoa(r.call)+`}`) {
+					t.Errorf("request %d answered %s, want call %d", i, response.Body, r.call)
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	s := newIdempotentServer(t)
+	started, release := make(chan bool), make(chan bool)
+	s.handler = func(c *gin.Context, call int) {
+		if call == 1 {
+			started <- true
+			<-release
+		}
+		c.JSON(http.StatusCreated, gin.H{"call": call})
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- s.post("k1", "u1", "10.0.0.1", `{"a":1}`) }()
+	<-started
+	if response := s.post("k1", "u1", "10.0.0.1", `{"a":1}`); response.Code != http.StatusConflict {
+		t.Errorf("a retry during the first request answered %d, want 409: %s", response.Code, response.Body)
+	}
+	close(release)
+	if response := <-first; response.Code != http.StatusCreated {
+		t.Fatalf("the first request answered %d: %s", response.Code, response.Body)
+	}
+	if response := s.post("k1", "u1", "10.0.0.1", `{"a":1}`); response.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("a retry after the first request was not replayed: %d %s", response.Code, response.Body)
+	}
+}
+
+func TestIdempotentReleasesFailures(t *testing.T) {
+	cases := []struct {
+		name string
+		fail func(c *gin.Context)
+	}{
+		{"server error", func(c *gin.Context) { c.JSON(http.StatusServiceUnavailable, gin.H{"error": "busy"}) }},
+		{"panic", func(c *gin.Context) { panic("handler failed") }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newIdempotentServer(t)
+			s.handler = func(ctx *gin.Context, call int) {
+				if call == 1 {
+					c.fail(ctx)
+					return
+				}
+				ctx.JSON(http.StatusCreated, gin.H{"call": call})
+			}
+			if response := s.post("k1", "u1", "10.0.0.1", `{"a":1}`); response.Code < http.StatusInternalServerError {
+				t.Fatalf("the failing request answered %d", response.Code)
+			}
+			response := s.post("k1", "u1", "10.0.0.1", `{"a":1}`)
+			if response.Code != http.StatusCreated || !strings.Contains(response.Body.String(), `"call":2`) {
+				t.Errorf("the retry answered %d %s, want it handled again", response.Code, response.Body)
+			}
+		})
+	}
+}
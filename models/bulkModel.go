@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Bulk operations.
+const (
+	BulkCreate  = "create"
+	BulkCheck   = "check"
+	BulkUncheck = "uncheck"
+	BulkEdit    = "edit"
+	BulkDelete  = "delete"
+	BulkMove    = "move"
+)
+
+// BulkRequest is a batch of todo operations applied in order. In Atomic mode
+// they all apply or, when one fails, none does.
+type BulkRequest struct {
+	Atomic     bool            `json:"atomic"`
+	Operations []BulkOperation `json:"operations" validate:"required,min=1"`
+}
+
+// BulkOperation is one operation of a batch on the todo with the given id.
+// Todo is the new todo of a create, Patch the JSON Merge Patch of an edit and
+// List_id the list a move puts the todo in, or no list. Version, when set,
+// must be the version of the todo, like an If-Match header, and is required
+// when If-Match is.
+type BulkOperation struct {
+	Op        string              `json:"op" validate:"required,oneof=create check uncheck edit delete move"`
+	Id        *primitive.ObjectID `json:"id"`
+	Version   *int64              `json:"version"`
+	Todo      *Todo               `json:"todo" validate:"-"`
+	Patch     json.RawMessage     `json:"patch"`
+	List_id   *primitive.ObjectID `json:"list_id"`
+	Permanent bool                `json:"permanent"`
+}
+
+// BulkResult reports what became of one operation of a batch, with the
+// status a single request doing it would have been answered with.
+type BulkResult struct {
+	Index  int                 `json:"index"`
+	Op     string              `json:"op"`
+	Status int                 `json:"status"`
+	Id     *primitive.ObjectID `json:"id,omitempty"`
+	Data   *Todo               `json:"data,omitempty"`
+	Next   *Todo               `json:"next,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
@@ -1,9 +1,36 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type DeleteModal struct {
-	ID    primitive.ObjectID `json:"id"`
-	User  User
-	Todos []Todo
+	ID         primitive.ObjectID `json:"id"`
+	User       User
+	Todos      []Todo
+	Tags       []Tag
+	Lists      []List
+	Deleted_at time.Time `json:"deleted_at"`
+}
+
+// Redacted returns a copy of the archive with its user redacted.
+func (archive DeleteModal) Redacted() DeleteModal {
+	archive.User = archive.User.Redacted()
+	return archive
+}
+
+// RedactArchives redacts every archive of the list in place.
+func RedactArchives(archives []DeleteModal) {
+	for i := range archives {
+		archives[i] = archives[i].Redacted()
+	}
+}
+
+// RestoreRequest optionally replaces the contact details of an archived user
+// whose email or phone has been taken by a user created since.
+type RestoreRequest struct {
+	Email *string `json:"email" validate:"omitempty,email"`
+	Phone *string `json:"phone" validate:"omitempty,min=1"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// IdempotencyRecord remembers a request sent with an Idempotency-Key and, once
+// it is Completed, the response to replay for retries of it. ID combines the
+// key with its scope, the user who sent it or, before logging in, the client.
+type IdempotencyRecord struct {
+	ID           string    `bson:"_id" json:"id"`
+	Fingerprint  string    `json:"fingerprint"`
+	Completed    bool      `json:"completed"`
+	Status       int       `json:"status"`
+	Content_type string    `json:"content_type"`
+	Body         []byte    `json:"body"`
+	Created_at   time.Time `json:"created_at"`
+	Expires_at   time.Time `json:"expires_at"`
+}
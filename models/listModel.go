@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// List groups todos of one user into a project. Lists are shown in Position
+// order; archived lists keep their todos but are hidden from the default
+// listing.
+type List struct {
+	ID          primitive.ObjectID `json:"id"`
+	User_id     string             `json:"user_id"`
+	Name        string             `json:"name" validate:"required,max=100"`
+	Position    int                `json:"position"`
+	Archived_at *time.Time         `json:"archived_at"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+	// Open_count and Done_count are computed when the list is read.
+	Open_count int64 `json:"open_count" bson:"-"`
+	Done_count int64 `json:"done_count" bson:"-"`
+}
+
+// ReorderListsRequest gives the ids of a user's lists in their new order.
+type ReorderListsRequest struct {
+	Ids []primitive.ObjectID `json:"ids" validate:"required,min=1"`
+}
+
+// MoveTodoRequest names the list a todo moves to, or no list when List_id is
+// null.
+type MoveTodoRequest struct {
+	List_id *primitive.ObjectID `json:"list_id"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Revision actions.
+const (
+	RevisionCreate  = "create"
+	RevisionEdit    = "edit"
+	RevisionCheck   = "check"
+	RevisionUncheck = "uncheck"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+)
+
+// SystemActor is the actor of the revisions the server records on its own,
+// such as those of the todos the purge removes.
+const SystemActor = "system"
+
+// Revision records one change of a todo: who made it, when, what changed and
+// the todo as it was afterwards. Revisions are never modified.
+type Revision struct {
+	ID         primitive.ObjectID `json:"id"`
+	Todo_id    primitive.ObjectID `json:"todo_id"`
+	User_id    string             `json:"user_id"`
+	Actor      string             `json:"actor"`
+	Action     string             `json:"action"`
+	Changes    []FieldChange      `json:"changes"`
+	Snapshot   Todo               `json:"snapshot"`
+	Created_at time.Time          `json:"created_at"`
+}
+
+// FieldChange is the value of one todo field before and after a change, in
+// its JSON form. Before is null for a created todo.
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
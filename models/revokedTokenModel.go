@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// RevokedToken marks an access token as unusable until it would have expired
+// anyway, after which the entry can be dropped.
+type RevokedToken struct {
+	Token_id   string    `json:"token_id"`
+	Expires_at time.Time `json:"expires_at"`
+}
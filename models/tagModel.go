@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tag is a label a user can attach to any number of their todos. Todos refer
+// to their tags by name, which is unique per user.
+type Tag struct {
+	ID         primitive.ObjectID `json:"id"`
+	User_id    string             `json:"user_id"`
+	Name       string             `json:"name" validate:"required,max=32,excludesall=0x2C"`
+	Color      string             `json:"color" validate:"omitempty,hexcolor"`
+	Created_at time.Time          `json:"created_at"`
+	Updated_at time.Time          `json:"updated_at"`
+}
+
+// MergeTagRequest names the tag the todos of a merged tag are moved to.
+type MergeTagRequest struct {
+	Into string `json:"into" validate:"required"`
+}
@@ -1,24 +1,116 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Todo priorities, from least to most urgent.
+const (
+	PriorityNone = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+)
+
 type Todo struct {
-	ID          primitive.ObjectID `json:"id"`
-	Title       string             `json:"title" validate:"required"`
-	Description string             `json:"description" validate:"required"`
-	User_id     string             `json:"user_id" validate:"required"`
-	Check       bool               `json:"check"`
-	Created_at  time.Time          `json:"created_at"`
-	Updated_at  time.Time          `json:"updated_at"`
+	ID          primitive.ObjectID  `json:"id"`
+	Title       string              `json:"title" validate:"required"`
+	Description string              `json:"description" validate:"required"`
+	User_id     string              `json:"user_id" validate:"required"`
+	Check       bool                `json:"check"`
+	Priority    int                 `json:"priority" validate:"min=0,max=3"`
+	Created_at  time.Time           `json:"created_at"`
+	Updated_at  time.Time           `json:"updated_at"`
+	Deleted_at  *time.Time          `json:"deleted_at"`
+	Due_at      *time.Time          `json:"due_at"`
+	Timezone    string              `json:"timezone" validate:"omitempty,timezone"`
+	Tags        []string            `json:"tags"`
+	List_id     *primitive.ObjectID `json:"list_id"`
+	// Position is the rank of the todo among the todos of its user; lists
+	// are shown in Position order unless another sort is asked for.
+	Position string          `json:"position"`
+	Items    []ChecklistItem `json:"items" validate:"dive"`
+	// Auto_check keeps Check in step with the items: the todo is checked once
+	// every item is, and unchecked again when one is not.
+	Auto_check   bool       `json:"auto_check"`
+	Completed_at *time.Time `json:"completed_at"`
+	// Recurrence is carried by the open occurrence of a recurring todo. All
+	// occurrences share the Series_id of the first one.
+	Recurrence *Recurrence         `json:"recurrence"`
+	Series_id  *primitive.ObjectID `json:"series_id"`
+	// Version counts the saves of the todo and is served as its ETag.
+	Version int64 `json:"version"`
+}
+
+// Recurrence frequencies.
+const (
+	RepeatDaily           = "daily"
+	RepeatWeekly          = "weekly"
+	RepeatMonthly         = "monthly"
+	RepeatAfterCompletion = "after_completion"
+)
+
+// Recurrence says when the next occurrence of a todo is due: every Interval
+// days, on the given Weekdays (0 is Sunday), on day Month_day of each month,
+// or Interval days after the previous occurrence was completed.
+type Recurrence struct {
+	Frequency string `json:"frequency" validate:"required,oneof=daily weekly monthly after_completion"`
+	Interval  int    `json:"interval" validate:"min=0,max=365"`
+	Weekdays  []int  `json:"weekdays" validate:"dive,min=0,max=6"`
+	Month_day int    `json:"month_day" validate:"min=0,max=31"`
+}
+
+// ChecklistItem is one step of a todo. Items are kept in Position order.
+type ChecklistItem struct {
+	ID       primitive.ObjectID `json:"id"`
+	Title    string             `json:"title" validate:"required,max=200"`
+	Check    bool               `json:"check"`
+	Position int                `json:"position"`
+}
+
+// Progress is the percentage of checked items, or 0 or 100 following Check
+// for a todo without items.
+func (todo Todo) Progress() int {
+	if len(todo.Items) == 0 {
+		if todo.Check {
+			return 100
+		}
+		return 0
+	}
+	done := 0
+	for _, item := range todo.Items {
+		if item.Check {
+			done++
+		}
+	}
+	return done * 100 / len(todo.Items)
+}
+
+// MarshalJSON adds the computed progress to the stored fields.
+func (todo Todo) MarshalJSON() ([]byte, error) {
+	type todoFields Todo
+	return json.Marshal(struct {
+		todoFields
+		Progress int `json:"progress"`
+	}{todoFields(todo), todo.Progress()})
 }
 
 type UpdateTodo struct {
-	Check   bool   `json:"check" validate:"required"`
-	User_id string `json:"user_id" validate:"required"`
+	Check bool `json:"check"`
 }
 
+// ReorderTodoRequest places a todo right after the todo After and right
+// before the todo Before. Either may be left out to only name one neighbour.
+type ReorderTodoRequest struct {
+	Before *primitive.ObjectID `json:"before"`
+	After  *primitive.ObjectID `json:"after"`
+}
 
+// ReorderItemsRequest gives the ids of a todo's checklist items in their new
+// order.
+type ReorderItemsRequest struct {
+	Ids []primitive.ObjectID `json:"ids" validate:"required,min=1"`
+}
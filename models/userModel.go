@@ -19,6 +19,49 @@ type User struct {
 	Created_at    time.Time          `json:"created_at"`
 	Updated_at    time.Time          `json:"updated_at"`
 	User_id       string             `json:"user_id"`
+	Timezone      *string            `json:"timezone" validate:"omitempty,timezone"`
+	Restored_by   *string            `json:"restored_by"`
+	Restored_at   *time.Time         `json:"restored_at"`
+	Sessions      []Session          `json:"-"`
+	// Version counts the saves of the user and is served as its ETag.
+	Version int64 `json:"version"`
 }
 
+// Redacted returns a copy of the user without the password hash and the
+// tokens, fit to be shown to an ADMIN looking at someone else's account.
+func (user User) Redacted() User {
+	user.Password = nil
+	user.Token = nil
+	user.Refresh_token = nil
+	user.Sessions = nil
+	return user
+}
+
+// RedactUsers redacts every user of the list in place.
+func RedactUsers(users []User) {
+	for i := range users {
+		users[i] = users[i].Redacted()
+	}
+}
+
+// Session is one refresh token family: it starts at login and only its most
+// recently issued refresh token may be exchanged for a new pair.
+type Session struct {
+	Family        string        `json:"family"`
+	Refresh_token string        `json:"refresh_token"`
+	Access_tokens []IssuedToken `json:"access_tokens"`
+	Expires_at    time.Time     `json:"expires_at"`
+	Created_at    time.Time     `json:"created_at"`
+	Updated_at    time.Time     `json:"updated_at"`
+}
+
+// IssuedToken identifies an access token by its jti so it can be revoked
+// before it expires.
+type IssuedToken struct {
+	Token_id   string    `json:"token_id"`
+	Expires_at time.Time `json:"expires_at"`
+}
 
+type RefreshRequest struct {
+	Refresh_token string `json:"refresh_token" validate:"required"`
+}
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"nitiwat/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AdminRouter(incomingRoutes *gin.RouterGroup) {
+	incomingRoutes.GET("/admin/purge/preview", controllers.PurgePreview())
+	incomingRoutes.GET("/admin/stats", controllers.GetAdminStats())
+}
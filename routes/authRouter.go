@@ -2,11 +2,13 @@ package routes
 
 import (
 	controller "nitiwat/controllers"
+	"nitiwat/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func AuthRouter(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/users/signup", controller.Signup())
+	incomingRoutes.POST("/users/signup", middleware.Idempotent(), controller.Signup())
 	incomingRoutes.POST("/users/login", controller.Login())
+	incomingRoutes.POST("/users/refresh", controller.RefreshToken())
 }
@@ -2,14 +2,13 @@ package routes
 
 import (
 	"nitiwat/controllers"
-	"nitiwat/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
-func DeletedRouter(incomingRoutes *gin.Engine) {
-	incomingRoutes.Use(middleware.Authenticate())
+func DeletedRouter(incomingRoutes *gin.RouterGroup) {
 	incomingRoutes.GET("/deleted", controllers.GetAllDeleted())
 	incomingRoutes.GET("/deleted/:del_id", controllers.GetDeletedById())
+	incomingRoutes.POST("/deleted/:del_id/restore", controllers.RestoreDeleted())
 
 }
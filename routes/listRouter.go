@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"nitiwat/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ListRouter(incomingRoutes *gin.RouterGroup) {
+	incomingRoutes.GET("/lists", controllers.GetLists())
+	incomingRoutes.GET("/lists/:list_id", controllers.GetListById())
+	incomingRoutes.POST("/lists", controllers.AddList())
+	incomingRoutes.POST("/lists/:list_id/archive", controllers.ArchiveList())
+	incomingRoutes.POST("/lists/:list_id/unarchive", controllers.UnarchiveList())
+	incomingRoutes.PUT("/lists/order", controllers.ReorderLists())
+	incomingRoutes.PUT("/lists/:list_id", controllers.RenameList())
+}
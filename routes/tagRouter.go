@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"nitiwat/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TagRouter(incomingRoutes *gin.RouterGroup) {
+	incomingRoutes.GET("/tags", controllers.GetTags())
+	incomingRoutes.GET("/tags/:tag_id", controllers.GetTagById())
+	incomingRoutes.POST("/tags", controllers.AddTag())
+	incomingRoutes.POST("/tags/:tag_id/merge", controllers.MergeTag())
+	incomingRoutes.PUT("/tags/:tag_id", controllers.UpdateTag())
+	incomingRoutes.DELETE("/tags/:tag_id", controllers.DeleteTag())
+}
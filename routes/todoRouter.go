@@ -7,16 +7,32 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func TodoRouter(incomingRoutes *gin.Engine) {
-	incomingRoutes.Use(middleware.Authenticate())
+func TodoRouter(incomingRoutes *gin.RouterGroup) {
 	incomingRoutes.GET("/todos", controllers.GetTodo())
+	incomingRoutes.GET("/todos/trash", controllers.GetTrash())
+	incomingRoutes.GET("/todos/search", controllers.SearchTodos())
+	incomingRoutes.GET("/todos/export", controllers.ExportTodos())
+	incomingRoutes.GET("/todos/overdue", controllers.GetOverdueTodos())
+	incomingRoutes.GET("/todos/today", controllers.GetTodayTodos())
+	incomingRoutes.GET("/todos/upcoming", controllers.GetUpcomingTodos())
+	incomingRoutes.GET("/stats", controllers.GetTodoStats())
 	incomingRoutes.GET("/todos/:todo_id", controllers.GetTodoById())
 	incomingRoutes.GET("/todos-user/:user_id", controllers.GetTodoByUser())
-	incomingRoutes.POST("/todos", controllers.AddTodo())
+	incomingRoutes.POST("/todos", middleware.Idempotent(), controllers.AddTodo())
+	incomingRoutes.POST("/todos/bulk", middleware.Idempotent(), controllers.BulkTodos())
+	incomingRoutes.POST("/todos/:todo_id/restore", controllers.RestoreTodo())
 	incomingRoutes.PUT("/todos/:todo_id", controllers.UpdateCheck())
 	incomingRoutes.PUT("/todos-update/:todo_id", controllers.UpdateEditTodo())
-	incomingRoutes.GET("todos-active", controllers.CheckALlTodoActive())
-	incomingRoutes.GET("todos-find", controllers.FindQuery())
+	incomingRoutes.PATCH("/todos/:todo_id", controllers.PatchTodo())
+	incomingRoutes.PUT("/todos/:todo_id/list", controllers.MoveTodo())
+	incomingRoutes.PUT("/todos/:todo_id/move", controllers.ReorderTodo())
+	incomingRoutes.GET("/todos/:todo_id/occurrences", controllers.GetTodoOccurrences())
+	incomingRoutes.GET("/todos/:todo_id/history", controllers.GetTodoHistory())
+	incomingRoutes.POST("/todos/:todo_id/history/:revision_id/revert", controllers.RevertTodo())
+	incomingRoutes.POST("/todos/:todo_id/items", controllers.AddChecklistItem())
+	incomingRoutes.PUT("/todos/:todo_id/items/order", controllers.ReorderChecklistItems())
+	incomingRoutes.PUT("/todos/:todo_id/items/:item_id", controllers.UpdateChecklistItem())
+	incomingRoutes.DELETE("/todos/:todo_id/items/:item_id", controllers.DeleteChecklistItem())
 	// incomingRoutes.GET("/todos/:todo_id", controller.GetTodo())
 	// incomingRoutes.PUT("/todos/:todo_id", controller.UpdateTodo())
 	incomingRoutes.DELETE("/todos/:todo_id", controllers.DeleteTodo())
@@ -2,13 +2,13 @@ package routes
 
 import (
 	"nitiwat/controllers"
-	"nitiwat/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
-func UserRouter(incomingRoutes *gin.Engine) {
-	incomingRoutes.Use(middleware.Authenticate())
+func UserRouter(incomingRoutes *gin.RouterGroup) {
+	incomingRoutes.POST("/users/logout", controllers.Logout())
+	incomingRoutes.POST("/users/logout-all", controllers.LogoutAll())
 	incomingRoutes.GET("/users", controllers.GetUsers())
 	incomingRoutes.GET("/users/:user_id", controllers.GetUser())
 	incomingRoutes.DELETE("/users/:user_id", controllers.DeleteUser())